@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// pageCheckpoint records after which page of key paths a copy or re-wrap got, so that an
+// interrupted run can be resumed.
+type pageCheckpoint struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Prefix string `json:"prefix"`
+	Token  string `json:"token"`
+}
+
+// readCheckpoint loads the checkpoint at path, if any, and checks that it belongs to the same run.
+func readCheckpoint(path string, want pageCheckpoint) (pageCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return want, nil
+	}
+	if err != nil {
+		return pageCheckpoint{}, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+
+	var checkpoint pageCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return pageCheckpoint{}, fmt.Errorf("failed to parse checkpoint %s: %v", path, err)
+	}
+	if checkpoint.From != want.From || checkpoint.To != want.To || checkpoint.Prefix != want.Prefix {
+		return pageCheckpoint{}, fmt.Errorf("checkpoint %s belongs to a different run; remove it to start over", path)
+	}
+	return checkpoint, nil
+}
+
+// writeCheckpoint saves the checkpoint, or removes it once the run is complete.
+func writeCheckpoint(path string, checkpoint pageCheckpoint) error {
+	if checkpoint.Token == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove checkpoint: %v", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that an interruption never leaves a torn checkpoint
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+
+	"github.com/ngoyal16/owlvault/config"
+	"github.com/ngoyal16/owlvault/storage"
+)
+
+// copyPageSize is the number of key paths copied between checkpoints.
+const copyPageSize = 100
+
+// runCopy streams every stored version from one storage to another as stored, without
+// decrypting it, and replays its deletion state. Key paths are copied a page at a time and
+// the position after each page is saved to the checkpoint file, so an interrupted copy
+// resumes from the last completed page; versions already present at the destination are
+// verified instead of written again.
+func runCopy(args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	from := flags.String("from", "", "configuration file of the source storage")
+	to := flags.String("to", "", "configuration file of the destination storage")
+	prefix := flags.String("prefix", "", "only copy key paths starting with this prefix")
+	checkpointPath := flags.String("checkpoint", "owlvault-copy.checkpoint", "file recording progress, used to resume an interrupted copy")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("both --from and --to are required")
+	}
+
+	ctx := context.Background()
+
+	srcCfg, err := config.ReadConfigFile(*from)
+	if err != nil {
+		return err
+	}
+	dstCfg, err := config.ReadConfigFile(*to)
+	if err != nil {
+		return err
+	}
+
+	src, err := storage.OpenStorage(srcCfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize source storage: %v", err)
+	}
+	dst, err := storage.NewStorage(ctx, dstCfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize destination storage: %v", err)
+	}
+
+	checkpoint := pageCheckpoint{From: *from, To: *to, Prefix: *prefix}
+	resumed, err := readCheckpoint(*checkpointPath, checkpoint)
+	if err != nil {
+		return err
+	}
+	if resumed.Token != "" {
+		fmt.Printf("resuming from checkpoint %s\n", *checkpointPath)
+	}
+	checkpoint.Token = resumed.Token
+
+	var keys, versions int
+	for {
+		summaries, next, err := src.List(ctx, *prefix, copyPageSize, checkpoint.Token)
+		if err != nil {
+			return fmt.Errorf("failed to list source keys: %v", err)
+		}
+
+		for _, summary := range summaries {
+			copied, err := copyKey(ctx, src, dst, summary.KeyPath)
+			if err != nil {
+				return fmt.Errorf("%s: %v", summary.KeyPath, err)
+			}
+			keys++
+			versions += copied
+		}
+
+		checkpoint.Token = next
+		if err := writeCheckpoint(*checkpointPath, checkpoint); err != nil {
+			return err
+		}
+		fmt.Printf("copied %d keys, %d versions\n", keys, versions)
+
+		if next == "" {
+			break
+		}
+	}
+
+	fmt.Printf("copy complete: %d keys, %d versions verified at the destination\n", keys, versions)
+	return nil
+}
+
+// copyKey copies every version of keyPath and returns how many versions the key has.
+func copyKey(ctx context.Context, src, dst storage.Storage, keyPath string) (int, error) {
+	records, err := src.Export(ctx, keyPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to export: %v", err)
+	}
+	infos, err := src.Versions(ctx, keyPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe versions: %v", err)
+	}
+	states := make(map[int]storage.VersionInfo, len(infos))
+	for _, info := range infos {
+		states[info.Version] = info
+	}
+
+	// Versions already at the destination were copied by an interrupted run
+	existing, err := dst.Export(ctx, keyPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read destination: %v", err)
+	}
+	copied := make(map[int]storage.Record, len(existing))
+	for _, record := range existing {
+		copied[record.Version] = record
+	}
+	existingInfos, err := dst.Versions(ctx, keyPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe destination versions: %v", err)
+	}
+	existingStates := make(map[int]storage.VersionInfo, len(existingInfos))
+	for _, info := range existingInfos {
+		existingStates[info.Version] = info
+	}
+
+	for _, record := range records {
+		state := states[record.Version]
+
+		if state.DestroyedAt == nil {
+			if err := verifyRecord(record); err != nil {
+				return 0, fmt.Errorf("version %d: %v", record.Version, err)
+			}
+		}
+
+		if prev, ok := copied[record.Version]; ok {
+			if state.DestroyedAt == nil && (prev.Contents != record.Contents || prev.HMAC != record.HMAC || prev.KPId != record.KPId) {
+				return 0, fmt.Errorf("version %d already exists at the destination with different contents", record.Version)
+			}
+		} else if err := dst.Store(ctx, record); err != nil {
+			return 0, fmt.Errorf("version %d: failed to store: %v", record.Version, err)
+		}
+
+		current, ok := existingStates[record.Version]
+		if !ok {
+			current = storage.VersionInfo{Version: record.Version}
+		}
+		if err := storage.ReplayState(ctx, dst, keyPath, state, current); err != nil {
+			return 0, fmt.Errorf("version %d: %v", record.Version, err)
+		}
+	}
+
+	stored, err := dst.Versions(ctx, keyPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to verify destination: %v", err)
+	}
+	if len(stored) != len(records) {
+		return 0, fmt.Errorf("destination holds %d versions, source holds %d", len(stored), len(records))
+	}
+
+	return len(records), nil
+}
+
+// verifyRecord checks that a live record's stored values decode, so that it stays readable once copied.
+func verifyRecord(record storage.Record) error {
+	if _, err := base64.StdEncoding.DecodeString(record.Contents); err != nil {
+		return fmt.Errorf("contents are not valid base64: %v", err)
+	}
+	if _, err := base64.StdEncoding.DecodeString(record.KPId); err != nil {
+		return fmt.Errorf("key provider id is not valid base64: %v", err)
+	}
+	mac, err := base64.StdEncoding.DecodeString(record.HMAC)
+	if err != nil {
+		return fmt.Errorf("HMAC is not valid base64: %v", err)
+	}
+	if len(mac) != sha256.Size {
+		return fmt.Errorf("HMAC is %d bytes, expected %d", len(mac), sha256.Size)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// command is a single owlvault-admin subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+// commands lists the available subcommands in the order they are shown in usage.
+var commands = []command{
+	{
+		name:  "migrate",
+		usage: "migrate [up|status]   apply pending storage migrations, or list them",
+		run:   runMigrate,
+	},
+	{
+		name:  "copy",
+		usage: "copy --from FILE --to FILE   copy every stored version between storages without decrypting it",
+		run:   runCopy,
+	},
+	{
+		name:  "reconcile",
+		usage: "reconcile [--repair]   compare the sides of a mirror storage and repair the secondary",
+		run:   runReconcile,
+	},
+	{
+		name:  "rewrap",
+		usage: "rewrap --from FILE --to FILE   re-wrap every stored data key under a new key provider",
+		run:   runRewrap,
+	},
+}
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %v", cmd.name, err)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: owlvault-admin <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Unless given as arguments, the configuration is read from OWLVAULT_CONFIG_PATH, as for the server.")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", cmd.usage)
+	}
+}
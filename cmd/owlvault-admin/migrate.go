@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ngoyal16/owlvault/config"
+	"github.com/ngoyal16/owlvault/storage"
+)
+
+// runMigrate applies or inspects the configured storage's schema migrations
+// without starting the HTTP server.
+func runMigrate(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	ctx := context.Background()
+
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	dbStorage, err := storage.OpenStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %v", err)
+	}
+
+	switch action {
+	case "up":
+		if err := dbStorage.Migrate(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("%s storage is up to date\n", cfg.Storage.Type)
+		return nil
+	case "status":
+		inspector, ok := dbStorage.(storage.MigrationInspector)
+		if !ok {
+			fmt.Printf("%s storage does not keep a schema migration history\n", cfg.Storage.Type)
+			return nil
+		}
+
+		statuses, err := inspector.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED AT\tDESCRIPTION")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, appliedAt, status.Description)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown action %q, expected up or status", action)
+	}
+}
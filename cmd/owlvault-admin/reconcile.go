@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/ngoyal16/owlvault/config"
+	"github.com/ngoyal16/owlvault/storage"
+)
+
+// runReconcile compares the two sides of the configured mirror storage and, with --repair,
+// brings the secondary in line with the primary.
+func runReconcile(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "only reconcile key paths starting with this prefix")
+	repair := flags.Bool("repair", false, "copy missing versions and replay deletion state on the secondary")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	dbStorage, err := storage.NewStorage(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %v", err)
+	}
+	mirror, ok := dbStorage.(*storage.MirrorStorage)
+	if !ok {
+		return fmt.Errorf("%s storage is not a mirror", cfg.Storage.Type)
+	}
+
+	report, err := mirror.Reconcile(ctx, *prefix, *repair)
+	printVersions("missing on secondary", report.Missing)
+	printVersions("deletion state diverged", report.StateDiverged)
+	printVersions("conflicting contents", report.Conflicts)
+	printVersions("only on secondary", report.SecondaryOnly)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("reconciled %d keys: %d missing, %d diverged, %d conflicting, %d only on secondary, %d repaired\n",
+		report.Keys, len(report.Missing), len(report.StateDiverged), len(report.Conflicts), len(report.SecondaryOnly), report.Repaired)
+	if len(report.Conflicts) > 0 || len(report.SecondaryOnly) > 0 {
+		return fmt.Errorf("conflicting and secondary-only versions must be resolved by hand")
+	}
+	return nil
+}
+
+// printVersions prints one line per version under a heading.
+func printVersions(heading string, keys []storage.VersionKey) {
+	for _, key := range keys {
+		fmt.Printf("%s: %s version %d\n", heading, key.KeyPath, key.Version)
+	}
+}
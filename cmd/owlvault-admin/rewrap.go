@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/ngoyal16/owlvault/config"
+	"github.com/ngoyal16/owlvault/encrypt"
+	"github.com/ngoyal16/owlvault/keyprovider"
+	"github.com/ngoyal16/owlvault/storage"
+	"github.com/ngoyal16/owlvault/vault"
+)
+
+// rewrapPageSize is the number of key paths re-wrapped between checkpoints.
+const rewrapPageSize = 100
+
+// runRewrap re-wraps the data key of every stored version from the key provider of one
+// configuration under the key provider of another, so that a master key can be rotated without
+// re-encrypting the contents. The storage and tenant are those of the new configuration. Key
+// paths are handled a page at a time and the position after each page is saved to the
+// checkpoint file, so an interrupted run resumes from the last completed page; versions that
+// are already readable with the new key provider are left as they are.
+func runRewrap(args []string) error {
+	flags := flag.NewFlagSet("rewrap", flag.ContinueOnError)
+	from := flags.String("from", "", "configuration file of the key provider the data keys are wrapped by")
+	to := flags.String("to", "", "configuration file of the storage and of the key provider to re-wrap the data keys under")
+	prefix := flags.String("prefix", "", "only re-wrap key paths starting with this prefix")
+	checkpointPath := flags.String("checkpoint", "owlvault-rewrap.checkpoint", "file recording progress, used to resume an interrupted re-wrap")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("both --from and --to are required")
+	}
+
+	ctx := context.Background()
+
+	oldCfg, err := config.ReadConfigFile(*from)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.ReadConfigFile(*to)
+	if err != nil {
+		return err
+	}
+	// The data keys stay bound to the same tenant, so it cannot change along with the key provider
+	if oldCfg.Server.Tenant != cfg.Server.Tenant {
+		return fmt.Errorf("the tenant differs between %s and %s", *from, *to)
+	}
+
+	oldKeyProvider, err := keyprovider.NewKeyProvider(oldCfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize old key provider: %v", err)
+	}
+	keyProvider, err := keyprovider.NewKeyProvider(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize new key provider: %v", err)
+	}
+	encryptor, err := encrypt.NewEncryptor(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize encryptor: %v", err)
+	}
+	dbStorage, err := storage.OpenStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %v", err)
+	}
+
+	owlVault := vault.NewOwlVault(dbStorage, keyProvider, encryptor,
+		vault.WithTimeouts(vault.Timeouts{
+			StorageRead:  cfg.Timeouts.StorageRead,
+			StorageWrite: cfg.Timeouts.StorageWrite,
+			KeyProvider:  cfg.Timeouts.KeyProvider,
+		}),
+		vault.WithTenant(cfg.Server.Tenant),
+	)
+
+	checkpoint := pageCheckpoint{From: *from, To: *to, Prefix: *prefix}
+	resumed, err := readCheckpoint(*checkpointPath, checkpoint)
+	if err != nil {
+		return err
+	}
+	if resumed.Token != "" {
+		fmt.Printf("resuming from checkpoint %s\n", *checkpointPath)
+	}
+	checkpoint.Token = resumed.Token
+
+	var keys int
+	var total vault.RewrapResult
+	for {
+		summaries, next, err := dbStorage.List(ctx, *prefix, rewrapPageSize, checkpoint.Token)
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %v", err)
+		}
+
+		for _, summary := range summaries {
+			result, err := owlVault.RewrapKey(ctx, summary.KeyPath, oldKeyProvider)
+			total.Rewrapped += result.Rewrapped
+			total.Current += result.Current
+			total.Destroyed += result.Destroyed
+			if err != nil {
+				return fmt.Errorf("%s: %v", summary.KeyPath, err)
+			}
+			keys++
+		}
+
+		checkpoint.Token = next
+		if err := writeCheckpoint(*checkpointPath, checkpoint); err != nil {
+			return err
+		}
+		fmt.Printf("re-wrapped %d keys: %d versions re-wrapped, %d already current, %d destroyed\n",
+			keys, total.Rewrapped, total.Current, total.Destroyed)
+
+		if next == "" {
+			break
+		}
+	}
+
+	fmt.Printf("re-wrap complete: %d keys, %d versions re-wrapped under the %s key provider\n", keys, total.Rewrapped, cfg.KeyProvider.Type)
+	return nil
+}
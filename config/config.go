@@ -6,37 +6,119 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Config represents the configuration for the OwlVault service.
 type Config struct {
 	Server struct {
 		Addr string `yaml:"addr"`
+		// CallerIdentityHeader names the request header recorded as the creator of stored versions.
+		CallerIdentityHeader string `yaml:"caller_identity_header"`
+		// TrustedProxies lists the IP addresses and CIDR ranges of the proxies allowed to set CallerIdentityHeader.
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		// Tenant is added to the KMS encryption context of every data key; it must not change once data is stored.
+		Tenant string `yaml:"tenant"`
 	} `yaml:"server"`
 	Encryptor struct {
 		Type string `yaml:"type"`
 	} `yaml:"encryptor"`
 	KeyProvider struct {
-		Type      string `yaml:"type"`
-		LocalFile struct {
-			Path string `yaml:"path"`
-		} `yaml:"local_file"`
-		AWSKMS struct {
-			KeyArn string `yaml:"key_arn"`
-			Region string `yaml:"region"`
-		} `yaml:"aws_kms"`
+		Type      string          `yaml:"type"`
+		LocalFile LocalFileConfig `yaml:"local_file"`
+		AWSKMS    AWSKMSConfig    `yaml:"aws_kms"`
+		Keyring   struct {
+			// Active is the id of the provider that wraps new data keys; the others only unwrap existing ones.
+			Active string `yaml:"active"`
+			// Untagged is the id of the provider that unwraps kp_ids stored before the keyring was configured.
+			Untagged  string                  `yaml:"untagged"`
+			Providers []KeyringProviderConfig `yaml:"providers"`
+		} `yaml:"keyring"`
 	} `yaml:"key_provider"`
 	Storage struct {
 		Type  string `yaml:"type"`
 		MySQL struct {
 			ConnectionString string `yaml:"connection_string"`
 		} `yaml:"mysql"`
+		PostgreSQL struct {
+			ConnectionString string `yaml:"connection_string"`
+		} `yaml:"postgresql"`
+		MongoDB struct {
+			ConnectionString string `yaml:"connection_string"`
+			DatabaseName     string `yaml:"database_name"`
+			CollectionName   string `yaml:"collection_name"`
+		} `yaml:"mongodb"`
 		DDB struct {
-			Region      string `yaml:"region"`
-			TablePrefix string `yaml:"table_prefix"`
+			Region              string `yaml:"region"`
+			TablePrefix         string `yaml:"table_prefix"`
+			Endpoint            string `yaml:"endpoint"`
+			BillingMode         string `yaml:"billing_mode"`
+			ReadCapacityUnits   int64  `yaml:"read_capacity_units"`
+			WriteCapacityUnits  int64  `yaml:"write_capacity_units"`
+			PointInTimeRecovery bool   `yaml:"point_in_time_recovery"`
+			SSE                 struct {
+				Enabled  bool   `yaml:"enabled"`
+				KMSKeyId string `yaml:"kms_key_id"`
+			} `yaml:"sse"`
+			Tags map[string]string `yaml:"tags"`
 		} `yaml:"dynamodb"`
+		BoltDB struct {
+			Path string `yaml:"path"`
+		} `yaml:"boltdb"`
+		Redis struct {
+			ConnectionString string `yaml:"connection_string"`
+			KeyPrefix        string `yaml:"key_prefix"`
+		} `yaml:"redis"`
+		S3 struct {
+			Region         string `yaml:"region"`
+			Bucket         string `yaml:"bucket"`
+			Prefix         string `yaml:"prefix"`
+			Endpoint       string `yaml:"endpoint"`
+			ForcePathStyle bool   `yaml:"force_path_style"`
+		} `yaml:"s3"`
+		Mirror struct {
+			PrimaryType   string `yaml:"primary_type"`
+			SecondaryType string `yaml:"secondary_type"`
+		} `yaml:"mirror"`
 		// Add other storage types here
 	} `yaml:"storage"`
+	Timeouts struct {
+		StorageRead  time.Duration `yaml:"storage_read"`
+		StorageWrite time.Duration `yaml:"storage_write"`
+		KeyProvider  time.Duration `yaml:"key_provider"`
+	} `yaml:"timeouts"`
+	Retention struct {
+		MaxVersions   int           `yaml:"max_versions"`
+		MaxAge        time.Duration `yaml:"max_age"`
+		PruneInterval time.Duration `yaml:"prune_interval"`
+		Prefixes      []struct {
+			Prefix      string        `yaml:"prefix"`
+			MaxVersions int           `yaml:"max_versions"`
+			MaxAge      time.Duration `yaml:"max_age"`
+		} `yaml:"prefixes"`
+	} `yaml:"retention"`
+}
+
+// LocalFileConfig configures a localfile key provider.
+type LocalFileConfig struct {
+	Path string `yaml:"path"`
+}
+
+// AWSKMSConfig configures an AWS KMS key provider.
+type AWSKMSConfig struct {
+	KeyArn         string        `yaml:"key_arn"`
+	Region         string        `yaml:"region"`
+	DataKeyMaxAge  time.Duration `yaml:"data_key_max_age"`
+	DataKeyMaxUses int           `yaml:"data_key_max_uses"`
+}
+
+// KeyringProviderConfig configures one of the key providers held by a keyring. The id is
+// recorded in every kp_id the provider wraps, so it must not change once data is stored.
+type KeyringProviderConfig struct {
+	ID        string          `yaml:"id"`
+	Type      string          `yaml:"type"`
+	LocalFile LocalFileConfig `yaml:"local_file"`
+	AWSKMS    AWSKMSConfig    `yaml:"aws_kms"`
 }
 
 // ReadConfig reads configuration from the specified YAML file path provided by the environment variable.
@@ -47,6 +129,11 @@ func ReadConfig() (*Config, error) {
 		return nil, fmt.Errorf("environment variable OWLVAULT_CONFIG_PATH is not set")
 	}
 
+	return ReadConfigFile(configPath)
+}
+
+// ReadConfigFile reads configuration from the YAML file at configPath.
+func ReadConfigFile(configPath string) (*Config, error) {
 	// Get the absolute path of the configuration file
 	absPath, err := filepath.Abs(configPath)
 	if err != nil {
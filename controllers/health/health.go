@@ -0,0 +1,63 @@
+package health
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ngoyal16/owlvault/vault"
+)
+
+// Statuses reported for the instance and for each component.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+type ComponentStatus struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+type Response struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+// Liveness returns a `func(*gin.Context)` reporting that the process is up. It does not check
+// any dependency, so an outage of the storage or key provider never restarts the instance.
+func Liveness() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, Response{Status: StatusOK})
+	}
+}
+
+// Readiness returns a `func(*gin.Context)` that checks the storage and key provider and
+// answers 503 Service Unavailable if any of them fails, so that load balancers stop routing
+// requests to the instance. The endpoint is unauthenticated, so only the status and latency
+// of each component are returned and the reason for a failure is logged.
+func Readiness(ov *vault.OwlVault) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code := http.StatusOK
+		response := Response{
+			Status:     StatusOK,
+			Components: map[string]ComponentStatus{},
+		}
+
+		for _, component := range ov.CheckHealth(c.Request.Context()) {
+			status := ComponentStatus{Status: StatusOK, LatencyMs: component.Latency.Milliseconds()}
+			if component.Err != nil {
+				// The error may name hosts, paths or keys, so it is only logged, not returned
+				log.Printf("readiness: %s is unavailable after %v: %v", component.Name, component.Latency, component.Err)
+				status.Status = StatusUnavailable
+
+				code = http.StatusServiceUnavailable
+				response.Status = StatusUnavailable
+			}
+			response.Components[component.Name] = status
+		}
+
+		c.JSON(code, response)
+	}
+}
@@ -1,5 +1,12 @@
 package ks2
 
+import (
+	"errors"
+
+	"github.com/ngoyal16/owlvault/storage"
+	"github.com/ngoyal16/owlvault/vault"
+)
+
 type Error struct {
 	Code    string `json:"code,omitempty"`
 	Message string `json:"message,omitempty"`
@@ -9,3 +16,31 @@ type ErrorResponse struct {
 	RequestId string  `json:"requestId,omitempty"`
 	Errors    []Error `json:"errors,omitempty"`
 }
+
+// versionMismatchError is reported when a check-and-set write finds the key at another version.
+var versionMismatchError = Error{
+	Code:    "VersionMismatch",
+	Message: "The key is not at the expected version. Retrieve the latest version and retry.",
+}
+
+// keyStateError maps errors about a key's existence or deletion state to the error reported to clients.
+func keyStateError(err error) (Error, bool) {
+	switch {
+	case errors.Is(err, vault.ErrKeyNotFound), errors.Is(err, storage.ErrVersionNotFound):
+		return Error{
+			Code:    "InvalidKey.KeyNotFound",
+			Message: "Specified key not found in the vault",
+		}, true
+	case errors.Is(err, storage.ErrVersionDeleted):
+		return Error{
+			Code:    "InvalidKey.KeyDeleted",
+			Message: "Specified key version has been deleted",
+		}, true
+	case errors.Is(err, storage.ErrVersionDestroyed):
+		return Error{
+			Code:    "InvalidKey.KeyDestroyed",
+			Message: "Specified key version has been destroyed",
+		}, true
+	}
+	return Error{}, false
+}
@@ -0,0 +1,110 @@
+package ks2
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ngoyal16/owlvault/models"
+	"github.com/ngoyal16/owlvault/vault"
+)
+
+type DeleteKeyRequest struct {
+	KeyPath  string `form:"keyPath" json:"keyPath" binding:"required"`
+	Versions []int  `form:"versions" json:"versions"`
+}
+
+type DeleteKeyResponseData struct {
+	KeyPath  string `json:"keyPath"`
+	Versions []int  `json:"versions"`
+}
+
+type DeleteKeyResponse struct {
+	RequestId string                `json:"requestId"`
+	Data      DeleteKeyResponseData `json:"data,omitempty"`
+}
+
+// DeleteKeyErrorResponse reports a deletion state change that failed after changing some of
+// the requested versions, which are listed in data.
+type DeleteKeyErrorResponse struct {
+	RequestId string                `json:"requestId"`
+	Errors    []Error               `json:"errors"`
+	Data      DeleteKeyResponseData `json:"data"`
+}
+
+// DeleteKey soft-deletes the requested versions of a key, or its latest version when none are given.
+// Deleted versions can be recovered with UndeleteKey.
+func DeleteKey(c *gin.Context, ov *vault.OwlVault) (int, any) {
+	return changeDeletionState(c, "DeleteKey", ov.DeleteVersions)
+}
+
+// UndeleteKey recovers soft-deleted versions of a key, or its latest version when none are given.
+func UndeleteKey(c *gin.Context, ov *vault.OwlVault) (int, any) {
+	return changeDeletionState(c, "UndeleteKey", ov.UndeleteVersions)
+}
+
+func changeDeletionState(c *gin.Context, action string, apply func(ctx context.Context, keyPath string, versions []int) ([]int, error)) (int, any) {
+	var deleteKeyRequest DeleteKeyRequest
+
+	if err := c.Bind(&deleteKeyRequest); err != nil {
+		var errors []Error
+
+		errorsTemp := models.FormatErrors(err)
+		for _, errorTemp := range errorsTemp {
+			errors = append(errors, Error{
+				Code:    "InvalidInput",
+				Message: errorTemp,
+			})
+		}
+
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors:    errors,
+		}
+	}
+
+	versions, err := apply(c.Request.Context(), deleteKeyRequest.KeyPath, deleteKeyRequest.Versions)
+	if err != nil {
+		return deletionStateError(action, deleteKeyRequest.KeyPath, versions, err)
+	}
+
+	return http.StatusOK, DeleteKeyResponse{
+		RequestId: uuid.New().String(),
+		Data: DeleteKeyResponseData{
+			KeyPath:  deleteKeyRequest.KeyPath,
+			Versions: versions,
+		},
+	}
+}
+
+// deletionStateError builds the response for a failed deletion state change, listing the
+// versions that were changed before the failure, if any.
+func deletionStateError(action, keyPath string, applied []int, err error) (int, any) {
+	log.Printf("%s %s failed: %v", action, keyPath, err)
+
+	keyErr, ok := keyStateError(err)
+	if !ok {
+		keyErr = Error{
+			Code:    "InternalFailure",
+			Message: "The request processing has failed because of an unknown error, exception, or failure.",
+		}
+	}
+
+	if len(applied) == 0 {
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors:    []Error{keyErr},
+		}
+	}
+	return http.StatusUnprocessableEntity, DeleteKeyErrorResponse{
+		RequestId: uuid.New().String(),
+		Errors:    []Error{keyErr},
+		Data: DeleteKeyResponseData{
+			KeyPath:  keyPath,
+			Versions: applied,
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package ks2
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ngoyal16/owlvault/models"
+	"github.com/ngoyal16/owlvault/vault"
+)
+
+type DescribeKeyRequest struct {
+	KeyPath string `form:"keyPath" json:"keyPath" binding:"required"`
+}
+
+type DescribeKeyResponseVersion struct {
+	Version     int        `json:"version"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	Encryptor   string     `json:"encryptor,omitempty"`
+	KeyProvider string     `json:"keyProvider,omitempty"`
+	CreatedBy   string     `json:"createdBy,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	DestroyedAt *time.Time `json:"destroyedAt,omitempty"`
+	PrunedAt    *time.Time `json:"prunedAt,omitempty"`
+}
+
+type DescribeKeyResponseData struct {
+	KeyPath       string                       `json:"keyPath"`
+	LatestVersion int                          `json:"latestVersion,omitempty"`
+	Versions      []DescribeKeyResponseVersion `json:"versions,omitempty"`
+	Errors        []Error                      `json:"errors,omitempty"`
+}
+
+type DescribeKeyResponse struct {
+	RequestId string                  `json:"requestId"`
+	Data      DescribeKeyResponseData `json:"data,omitempty"`
+}
+
+// DescribeKey lists the versions of a key path with their metadata, without decrypting any data.
+func DescribeKey(c *gin.Context, ov *vault.OwlVault) (int, any) {
+	var describeKeyRequest DescribeKeyRequest
+
+	if err := c.Bind(&describeKeyRequest); err != nil {
+		var errors []Error
+
+		errorsTemp := models.FormatErrors(err)
+		for _, errorTemp := range errorsTemp {
+			errors = append(errors, Error{
+				Code:    "InvalidInput",
+				Message: errorTemp,
+			})
+		}
+
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors:    errors,
+		}
+	}
+
+	versions, err := ov.DescribeKey(c.Request.Context(), describeKeyRequest.KeyPath)
+	if err != nil {
+		if keyErr, ok := keyStateError(err); ok {
+			return http.StatusOK, DescribeKeyResponse{
+				RequestId: uuid.New().String(),
+				Data: DescribeKeyResponseData{
+					KeyPath: describeKeyRequest.KeyPath,
+					Errors:  []Error{keyErr},
+				},
+			}
+		}
+		log.Printf("DescribeKey %s failed: %v", describeKeyRequest.KeyPath, err)
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors: []Error{
+				{
+					Code:    "InternalFailure",
+					Message: "The request processing has failed because of an unknown error, exception, or failure.",
+				},
+			},
+		}
+	}
+
+	data := DescribeKeyResponseData{
+		KeyPath:  describeKeyRequest.KeyPath,
+		Versions: make([]DescribeKeyResponseVersion, 0, len(versions)),
+	}
+	for _, version := range versions {
+		// Versions written before metadata was recorded have no creation time
+		var createdAt *time.Time
+		if !version.Metadata.CreatedAt.IsZero() {
+			t := version.Metadata.CreatedAt
+			createdAt = &t
+		}
+
+		data.Versions = append(data.Versions, DescribeKeyResponseVersion{
+			Version:     version.Version,
+			CreatedAt:   createdAt,
+			Encryptor:   version.Metadata.Encryptor,
+			KeyProvider: version.Metadata.KeyProvider,
+			CreatedBy:   version.Metadata.CreatedBy,
+			DeletedAt:   version.DeletedAt,
+			DestroyedAt: version.DestroyedAt,
+			PrunedAt:    version.PrunedAt,
+		})
+		if version.Version > data.LatestVersion {
+			data.LatestVersion = version.Version
+		}
+	}
+
+	return http.StatusOK, DescribeKeyResponse{
+		RequestId: uuid.New().String(),
+		Data:      data,
+	}
+}
@@ -0,0 +1,56 @@
+package ks2
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ngoyal16/owlvault/models"
+	"github.com/ngoyal16/owlvault/vault"
+)
+
+type DestroyKeyVersionRequest struct {
+	KeyPath  string `form:"keyPath" json:"keyPath" binding:"required"`
+	Versions []int  `form:"versions" json:"versions" binding:"required,min=1"`
+}
+
+type DestroyKeyVersionResponse struct {
+	RequestId string                `json:"requestId"`
+	Data      DeleteKeyResponseData `json:"data,omitempty"`
+}
+
+// DestroyKeyVersion permanently erases the contents of the requested versions of a key.
+func DestroyKeyVersion(c *gin.Context, ov *vault.OwlVault) (int, any) {
+	var destroyKeyVersionRequest DestroyKeyVersionRequest
+
+	if err := c.Bind(&destroyKeyVersionRequest); err != nil {
+		var errors []Error
+
+		errorsTemp := models.FormatErrors(err)
+		for _, errorTemp := range errorsTemp {
+			errors = append(errors, Error{
+				Code:    "InvalidInput",
+				Message: errorTemp,
+			})
+		}
+
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors:    errors,
+		}
+	}
+
+	versions, err := ov.DestroyVersions(c.Request.Context(), destroyKeyVersionRequest.KeyPath, destroyKeyVersionRequest.Versions)
+	if err != nil {
+		return deletionStateError("DestroyKeyVersion", destroyKeyVersionRequest.KeyPath, versions, err)
+	}
+
+	return http.StatusOK, DestroyKeyVersionResponse{
+		RequestId: uuid.New().String(),
+		Data: DeleteKeyResponseData{
+			KeyPath:  destroyKeyVersionRequest.KeyPath,
+			Versions: versions,
+		},
+	}
+}
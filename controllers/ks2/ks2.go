@@ -35,6 +35,16 @@ func KS2(ov *vault.OwlVault) gin.HandlerFunc {
 		case "RetrieveKeys":
 			fmt.Println(requestType)
 			code, response = RetrieveKeys(c, ov)
+		case "ListKeys":
+			code, response = ListKeys(c, ov)
+		case "DescribeKey":
+			code, response = DescribeKey(c, ov)
+		case "DeleteKey":
+			code, response = DeleteKey(c, ov)
+		case "UndeleteKey":
+			code, response = UndeleteKey(c, ov)
+		case "DestroyKeyVersion":
+			code, response = DestroyKeyVersion(c, ov)
 		default:
 			code = http.StatusBadRequest
 			response = ErrorResponse{
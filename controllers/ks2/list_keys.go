@@ -0,0 +1,98 @@
+package ks2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ngoyal16/owlvault/models"
+	"github.com/ngoyal16/owlvault/storage"
+	"github.com/ngoyal16/owlvault/vault"
+)
+
+type ListKeysRequest struct {
+	Prefix            string `form:"prefix" json:"prefix"`
+	PageSize          int    `form:"pageSize" json:"pageSize" binding:"omitempty,min=1,max=1000"`
+	ContinuationToken string `form:"continuationToken" json:"continuationToken"`
+}
+
+type ListKeysResponseKey struct {
+	KeyPath       string `json:"keyPath"`
+	LatestVersion int    `json:"latestVersion"`
+}
+
+type ListKeysResponseData struct {
+	Keys              []ListKeysResponseKey `json:"keys"`
+	ContinuationToken string                `json:"continuationToken,omitempty"`
+}
+
+type ListKeysResponse struct {
+	RequestId string               `json:"requestId"`
+	Data      ListKeysResponseData `json:"data,omitempty"`
+}
+
+// ListKeys returns a page of key paths, optionally filtered by prefix.
+func ListKeys(c *gin.Context, ov *vault.OwlVault) (int, any) {
+	var listKeysRequest ListKeysRequest
+
+	if err := c.Bind(&listKeysRequest); err != nil {
+		var errors []Error
+
+		errorsTemp := models.FormatErrors(err)
+		for _, errorTemp := range errorsTemp {
+			errors = append(errors, Error{
+				Code:    "InvalidInput",
+				Message: errorTemp,
+			})
+		}
+
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors:    errors,
+		}
+	}
+
+	summaries, next, err := ov.ListKeys(c.Request.Context(), listKeysRequest.Prefix, listKeysRequest.PageSize, listKeysRequest.ContinuationToken)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidToken) {
+			return http.StatusUnprocessableEntity, ErrorResponse{
+				RequestId: uuid.New().String(),
+				Errors: []Error{
+					{
+						Code:    "InvalidInput",
+						Message: "ContinuationToken: ContinuationToken is not valid",
+					},
+				},
+			}
+		}
+		log.Printf("ListKeys %q failed: %v", listKeysRequest.Prefix, err)
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors: []Error{
+				{
+					Code:    "InternalFailure",
+					Message: "The request processing has failed because of an unknown error, exception, or failure.",
+				},
+			},
+		}
+	}
+
+	keys := make([]ListKeysResponseKey, 0, len(summaries))
+	for _, summary := range summaries {
+		keys = append(keys, ListKeysResponseKey{
+			KeyPath:       summary.KeyPath,
+			LatestVersion: summary.LatestVersion,
+		})
+	}
+
+	return http.StatusOK, ListKeysResponse{
+		RequestId: uuid.New().String(),
+		Data: ListKeysResponseData{
+			Keys:              keys,
+			ContinuationToken: next,
+		},
+	}
+}
@@ -50,24 +50,19 @@ func RetrieveKey(c *gin.Context, ov *vault.OwlVault) (int, any) {
 	var keyData map[string]interface{}
 	var err error
 	if retrieveKeyRequest.Version == 0 {
-		keyData, err = ov.RetrieveLatestVersion(retrieveKeyRequest.KeyPath)
+		keyData, err = ov.RetrieveLatestVersion(c.Request.Context(), retrieveKeyRequest.KeyPath)
 	} else {
-		keyData, err = ov.RetrieveVersion(retrieveKeyRequest.KeyPath, retrieveKeyRequest.Version)
+		keyData, err = ov.RetrieveVersion(c.Request.Context(), retrieveKeyRequest.KeyPath, retrieveKeyRequest.Version)
 	}
 
 	if err != nil {
 		fmt.Println(err)
-		if err.Error() == "NO_KEY_FOUND" {
+		if keyErr, ok := keyStateError(err); ok {
 			return http.StatusOK, RetrieveKeyResponse{
 				RequestId: uuid.New().String(),
 				Data: RetrieveKeyResponseData{
 					KeyPath: retrieveKeyRequest.KeyPath,
-					Errors: []Error{
-						{
-							Code:    "InvalidKey.KeyNotFound",
-							Message: "Specified key not found in the vault",
-						},
-					},
+					Errors:  []Error{keyErr},
 				},
 			}
 		} else {
@@ -1,7 +1,7 @@
 package ks2
 
 import (
-	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -40,54 +40,47 @@ func RetrieveKeys(c *gin.Context, ov *vault.OwlVault) (int, any) {
 		}
 	}
 
-	var retrieveKeysResponseData []RetrieveKeyResponseData
-
+	requests := make([]vault.RetrieveRequest, 0, len(retrieveKeysRequest.KeysToRetrieve))
 	for _, retrieveKeyRequest := range retrieveKeysRequest.KeysToRetrieve {
-		var keyData map[string]interface{}
-		var err error
-		if retrieveKeyRequest.Version == 0 {
-			keyData, err = ov.RetrieveLatestVersion(retrieveKeyRequest.KeyPath)
-		} else {
-			keyData, err = ov.RetrieveVersion(retrieveKeyRequest.KeyPath, retrieveKeyRequest.Version)
-		}
-
-		if err != nil {
-			if err.Error() == "NO_KEY_FOUND" {
-				retrieveKeysResponseData = append(retrieveKeysResponseData, RetrieveKeyResponseData{
-					KeyPath: retrieveKeyRequest.KeyPath,
-					Errors: []Error{
-						{
-							Code:    "InvalidKey.KeyNotFound",
-							Message: "Specified key not found in the vault",
-						},
-					},
-				})
-			} else {
-				retrieveKeysResponseData = append(retrieveKeysResponseData, RetrieveKeyResponseData{
-					KeyPath: retrieveKeyRequest.KeyPath,
-					Errors: []Error{
-						{
-							Code:    "InternalFailure",
-							Message: "The request processing has failed because of an unknown error, exception, or failure.",
-						},
-					},
-				})
-			}
+		requests = append(requests, vault.RetrieveRequest{
+			KeyPath: retrieveKeyRequest.KeyPath,
+			Version: retrieveKeyRequest.Version,
+		})
+	}
 
-			fmt.Println(err)
-			return http.StatusUnprocessableEntity, ErrorResponse{
-				RequestId: uuid.New().String(),
-				Errors: []Error{
-					{
-						Code:    "InternalFailure",
-						Message: "The request processing has failed because of an unknown error, exception, or failure.",
-					},
+	results, err := ov.RetrieveBatch(c.Request.Context(), requests)
+	if err != nil {
+		log.Printf("RetrieveKeys of %d keys failed: %v", len(requests), err)
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors: []Error{
+				{
+					Code:    "InternalFailure",
+					Message: "The request processing has failed because of an unknown error, exception, or failure.",
 				},
+			},
+		}
+	}
+
+	retrieveKeysResponseData := make([]RetrieveKeyResponseData, 0, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			keyErr, ok := keyStateError(result.Err)
+			if !ok {
+				log.Printf("RetrieveKeys %s failed: %v", result.KeyPath, result.Err)
+				keyErr = Error{
+					Code:    "InternalFailure",
+					Message: "The request processing has failed because of an unknown error, exception, or failure.",
+				}
 			}
+			retrieveKeysResponseData = append(retrieveKeysResponseData, RetrieveKeyResponseData{
+				KeyPath: result.KeyPath,
+				Errors:  []Error{keyErr},
+			})
 		} else {
 			retrieveKeysResponseData = append(retrieveKeysResponseData, RetrieveKeyResponseData{
-				KeyPath: retrieveKeyRequest.KeyPath,
-				Data:    keyData,
+				KeyPath: result.KeyPath,
+				Data:    result.Data,
 			})
 		}
 	}
@@ -1,6 +1,7 @@
 package ks2
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -8,17 +9,20 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/ngoyal16/owlvault/models"
+	"github.com/ngoyal16/owlvault/storage"
 	"github.com/ngoyal16/owlvault/vault"
 )
 
 type StoreKeyRequest struct {
-	KeyPath string                 `form:"keyPath" json:"keyPath" binding:"required"`
-	Data    map[string]interface{} `from:"data" json:"data" binding:"required"`
+	KeyPath         string                 `form:"keyPath" json:"keyPath" binding:"required"`
+	Data            map[string]interface{} `from:"data" json:"data" binding:"required"`
+	ExpectedVersion *int                   `form:"expectedVersion" json:"expectedVersion" binding:"omitempty,min=0"`
 }
 
 type StoreKeyResponseData struct {
-	KeyPath string `json:"keyPath"`
-	Version int    `json:"version"`
+	KeyPath string  `json:"keyPath"`
+	Version int     `json:"version,omitempty"`
+	Errors  []Error `json:"errors,omitempty"`
 }
 
 type StoreKeyResponse struct {
@@ -46,9 +50,32 @@ func StoreKey(c *gin.Context, ov *vault.OwlVault) (int, any) {
 		}
 	}
 
-	lVersion, err := ov.StoreData(storeKeyRequest.KeyPath, storeKeyRequest.Data)
+	var lVersion int
+	var err error
+	if storeKeyRequest.ExpectedVersion == nil {
+		lVersion, err = ov.StoreData(c.Request.Context(), storeKeyRequest.KeyPath, storeKeyRequest.Data)
+	} else {
+		lVersion, err = ov.StoreDataIfVersion(c.Request.Context(), storeKeyRequest.KeyPath, storeKeyRequest.Data, *storeKeyRequest.ExpectedVersion)
+	}
 	if err != nil {
 		fmt.Println(err)
+		if errors.Is(err, vault.ErrVersionMismatch) {
+			return http.StatusConflict, ErrorResponse{
+				RequestId: uuid.New().String(),
+				Errors:    []Error{versionMismatchError},
+			}
+		}
+		if errors.Is(err, storage.ErrVersionConflict) {
+			return http.StatusConflict, ErrorResponse{
+				RequestId: uuid.New().String(),
+				Errors: []Error{
+					{
+						Code:    "ConcurrentModification",
+						Message: "The key was modified concurrently by other requests. Retry the request.",
+					},
+				},
+			}
+		}
 		return http.StatusUnprocessableEntity, ErrorResponse{
 			RequestId: uuid.New().String(),
 			Errors: []Error{
@@ -1,18 +1,21 @@
 package ks2
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"github.com/ngoyal16/owlvault/models"
+	"github.com/ngoyal16/owlvault/storage"
 	"github.com/ngoyal16/owlvault/vault"
 )
 
 type StoreKeysRequest struct {
 	KeysToStore []StoreKeyRequest `form:"keysToStore" json:"keysToStore" binding:"required"`
+	Atomic      bool              `form:"atomic" json:"atomic"`
 }
 
 type StoreKeysResponse struct {
@@ -40,27 +43,104 @@ func StoreKeys(c *gin.Context, ov *vault.OwlVault) (int, any) {
 		}
 	}
 
-	var storeKeyResponseData []StoreKeyResponseData
-
+	requests := make([]vault.StoreRequest, 0, len(storeKeysRequest.KeysToStore))
 	for _, storeKeyRequest := range storeKeysRequest.KeysToStore {
-		lVersion, err := ov.StoreData(storeKeyRequest.KeyPath, storeKeyRequest.Data)
-		if err != nil {
-			fmt.Println(err)
+		requests = append(requests, vault.StoreRequest{
+			KeyPath:         storeKeyRequest.KeyPath,
+			Data:            storeKeyRequest.Data,
+			ExpectedVersion: storeKeyRequest.ExpectedVersion,
+		})
+	}
+
+	if storeKeysRequest.Atomic {
+		return storeKeysAtomic(c, ov, requests)
+	}
+
+	results, err := ov.StoreBatch(c.Request.Context(), requests)
+	if err != nil {
+		log.Printf("StoreKeys of %d keys failed: %v", len(requests), err)
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors: []Error{
+				{
+					Code:    "InternalFailure",
+					Message: "The request processing has failed because of an unknown error, exception, or failure.",
+				},
+			},
+		}
+	}
+
+	storeKeyResponseData := make([]StoreKeyResponseData, 0, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("StoreKeys %s failed: %v", result.KeyPath, result.Err)
+			storeKeyResponseData = append(storeKeyResponseData, StoreKeyResponseData{
+				KeyPath: result.KeyPath,
+				Errors:  []Error{storeKeyError(result.Err)},
+			})
+		} else {
+			storeKeyResponseData = append(storeKeyResponseData, StoreKeyResponseData{
+				KeyPath: result.KeyPath,
+				Version: result.Version,
+			})
+		}
+	}
+
+	return http.StatusOK, StoreKeysResponse{
+		RequestId: uuid.New().String(),
+		Data:      storeKeyResponseData,
+	}
+}
+
+// storeKeysAtomic stores all of the keys or none of them, reporting a single error for the whole request.
+func storeKeysAtomic(c *gin.Context, ov *vault.OwlVault, requests []vault.StoreRequest) (int, any) {
+	results, err := ov.StoreAtomic(c.Request.Context(), requests)
+	if err != nil {
+		log.Printf("StoreKeys of %d keys atomically failed: %v", len(requests), err)
+		switch {
+		case errors.Is(err, vault.ErrVersionMismatch):
+			return http.StatusConflict, ErrorResponse{
+				RequestId: uuid.New().String(),
+				Errors:    []Error{versionMismatchError},
+			}
+		case errors.Is(err, storage.ErrVersionConflict):
+			return http.StatusConflict, ErrorResponse{
+				RequestId: uuid.New().String(),
+				Errors: []Error{
+					{
+						Code:    "ConcurrentModification",
+						Message: "The key was modified concurrently by other requests. Retry the request.",
+					},
+				},
+			}
+		case errors.Is(err, storage.ErrAtomicBatchTooLarge):
 			return http.StatusUnprocessableEntity, ErrorResponse{
 				RequestId: uuid.New().String(),
 				Errors: []Error{
 					{
-						Code:    "InternalFailure",
-						Message: "The request processing has failed because of an unknown error, exception, or failure.",
+						Code:    "InvalidInput",
+						Message: "KeysToStore: " + err.Error(),
 					},
 				},
 			}
-		} else {
-			storeKeyResponseData = append(storeKeyResponseData, StoreKeyResponseData{
-				KeyPath: storeKeyRequest.KeyPath,
-				Version: lVersion,
-			})
 		}
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			RequestId: uuid.New().String(),
+			Errors: []Error{
+				{
+					Code:    "InternalFailure",
+					Message: "The request processing has failed because of an unknown error, exception, or failure.",
+				},
+			},
+		}
+	}
+
+	storeKeyResponseData := make([]StoreKeyResponseData, 0, len(results))
+	for _, result := range results {
+		storeKeyResponseData = append(storeKeyResponseData, StoreKeyResponseData{
+			KeyPath: result.KeyPath,
+			Version: result.Version,
+		})
 	}
 
 	return http.StatusOK, StoreKeysResponse{
@@ -68,3 +148,20 @@ func StoreKeys(c *gin.Context, ov *vault.OwlVault) (int, any) {
 		Data:      storeKeyResponseData,
 	}
 }
+
+// storeKeyError maps the reason a single key of a batch was not stored to the error reported to clients.
+func storeKeyError(err error) Error {
+	if errors.Is(err, vault.ErrVersionMismatch) {
+		return versionMismatchError
+	}
+	if errors.Is(err, storage.ErrVersionConflict) {
+		return Error{
+			Code:    "ConcurrentModification",
+			Message: "The key was modified concurrently by other requests. Retry the request.",
+		}
+	}
+	return Error{
+		Code:    "InternalFailure",
+		Message: "The request processing has failed because of an unknown error, exception, or failure.",
+	}
+}
@@ -9,8 +9,8 @@ import (
 
 // KeyManagementService defines methods for encryption and decryption.
 type KeyManagementService interface {
-	Encrypt(data []byte) ([]byte, error)
-	Decrypt(data []byte) ([]byte, error)
+	Encrypt(ctx context.Context, data []byte) ([]byte, error)
+	Decrypt(ctx context.Context, data []byte) ([]byte, error)
 }
 
 // AWSKMSProvider represents an AWS KMS provider for encryption and decryption.
@@ -54,13 +54,13 @@ func NewAWSKMSProvider(region string, opts ...NewAWSKMSProviderOption) (*AWSKMSP
 }
 
 // Encrypt encrypts data using the AWS KMS service.
-func (kp *AWSKMSProvider) Encrypt(data []byte) ([]byte, error) {
+func (kp *AWSKMSProvider) Encrypt(ctx context.Context, data []byte) ([]byte, error) {
 	input := &kms.EncryptInput{
 		KeyId:     aws.String("default"), // Update with your KMS key ID
 		Plaintext: data,
 	}
 
-	result, err := kp.client.Encrypt(context.Background(), input)
+	result, err := kp.client.Encrypt(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -69,12 +69,12 @@ func (kp *AWSKMSProvider) Encrypt(data []byte) ([]byte, error) {
 }
 
 // Decrypt decrypts data using the AWS KMS service.
-func (kp *AWSKMSProvider) Decrypt(data []byte) ([]byte, error) {
+func (kp *AWSKMSProvider) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
 	input := &kms.DecryptInput{
 		CiphertextBlob: data,
 	}
 
-	result, err := kp.client.Decrypt(context.Background(), input)
+	result, err := kp.client.Decrypt(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -1,7 +1,9 @@
 package awskms
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"sync"
 	"time"
@@ -10,9 +12,35 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/ngoyal16/owlvault/keyprovider/common"
+)
+
+// Defaults for the data key rotation limits.
+const (
+	DefaultDataKeyMaxAge  = 24 * time.Hour
+	DefaultDataKeyMaxUses = 100000
 )
 
+// MinDataKeyMaxAge is the shortest maximum age accepted for a data key. Shorter ones would
+// have GenerateKey call AWS KMS for nearly every new version.
+const MinDataKeyMaxAge = time.Minute
+
+// maxCachedDataKeys bounds how many encryption contexts GenerateKey keeps a data key for.
+const maxCachedDataKeys = 10000
+
+// contextBlobMagic prefixes the provider blob of data keys generated with an encryption
+// context, telling them apart from the bare KMS ciphertext blobs written before. Stripping
+// it does not unbind a blob: KMS still requires the context to decrypt it.
+var contextBlobMagic = []byte("owlvault-ec1\x00")
+
 // AWSKMSKeyProvider implements the KeyProvider interface for retrieving keys from AWS KMS.
+// Data keys are generated with the caller's encryption context, which KMS records in
+// CloudTrail and requires again to decrypt them. For each encryption context GenerateKey
+// hands out the same data key until it reaches its maximum age or number of uses, then
+// generates a new one, bounding how much data a single data key protects.
 type AWSKMSKeyProvider struct {
 	sync.RWMutex
 
@@ -21,14 +49,61 @@ type AWSKMSKeyProvider struct {
 
 	keyId string
 
-	svc *kms.KMS
+	svc kmsiface.KMSAPI
+
+	rotation rotationSettings
+
+	dataKeyMu     sync.Mutex
+	dataKeys      map[string]*dataKey
+	generating    singleflight.Group
+	keyCacheStore *bigcache.BigCache
+}
+
+// rotationSettings bounds the lifetime of a data key.
+type rotationSettings struct {
+	maxAge  time.Duration
+	maxUses int
+}
 
-	isEncKeyExists bool
-	encKey         *kms.GenerateDataKeyOutput
-	keyCacheStore  *bigcache.BigCache
+// dataKey is the data key GenerateKey currently hands out for an encryption context.
+type dataKey struct {
+	plaintext []byte
+	blob      []byte
+	createdAt time.Time
+	uses      int
 }
 
-func NewAWSKMSKeyProvider(region string, keyId string) (*AWSKMSKeyProvider, error) {
+// Option represents an option for configuring a new AWSKMSKeyProvider.
+type Option func(*rotationSettings)
+
+// WithDataKeyRotation replaces the data key once it is maxAge old or has been handed out
+// maxUses times. A zero value keeps the corresponding default.
+func WithDataKeyRotation(maxAge time.Duration, maxUses int) Option {
+	return func(r *rotationSettings) {
+		if maxAge != 0 {
+			r.maxAge = maxAge
+		}
+		if maxUses != 0 {
+			r.maxUses = maxUses
+		}
+	}
+}
+
+func NewAWSKMSKeyProvider(region string, keyId string, opts ...Option) (*AWSKMSKeyProvider, error) {
+	rotation := rotationSettings{
+		maxAge:  DefaultDataKeyMaxAge,
+		maxUses: DefaultDataKeyMaxUses,
+	}
+	for _, opt := range opts {
+		opt(&rotation)
+	}
+	if rotation.maxAge < MinDataKeyMaxAge {
+		return nil, fmt.Errorf("data key max age must be at least %v, got %v", MinDataKeyMaxAge, rotation.maxAge)
+	}
+	if rotation.maxUses < 0 {
+		return nil, fmt.Errorf("data key max uses must be positive, got %d", rotation.maxUses)
+	}
+
 	// Initialize DynamoDB client
 	sess, err := session.NewSession(&aws.Config{
 		Region: aws.String(region),
@@ -36,8 +111,12 @@ func NewAWSKMSKeyProvider(region string, keyId string) (*AWSKMSKeyProvider, erro
 	if err != nil {
 		return nil, err
 	}
-	svc := kms.New(sess)
 
+	return newAWSKMSKeyProvider(region, keyId, kms.New(sess), rotation)
+}
+
+// newAWSKMSKeyProvider creates an AWSKMSKeyProvider on the given AWS KMS client.
+func newAWSKMSKeyProvider(region, keyId string, svc kmsiface.KMSAPI, rotation rotationSettings) (*AWSKMSKeyProvider, error) {
 	cache, err := bigcache.New(context.Background(), bigcache.DefaultConfig(10*time.Minute))
 	if err != nil {
 		return nil, fmt.Errorf("error encountered while creating kms key provider cache: %v", err)
@@ -47,42 +126,137 @@ func NewAWSKMSKeyProvider(region string, keyId string) (*AWSKMSKeyProvider, erro
 		region:        region,
 		keyId:         keyId,
 		svc:           svc,
+		rotation:      rotation,
+		dataKeys:      make(map[string]*dataKey),
 		keyCacheStore: cache,
 	}, nil
 }
 
-// GenerateKey generates a new encryption key using AWS KMS.
-func (kp *AWSKMSKeyProvider) GenerateKey() ([]byte, []byte, []byte, error) {
-	var resp *kms.GenerateDataKeyOutput
-	var err error
+// GenerateKey returns the current data key for the encryption context, generating a new one
+// from AWS KMS first if there is none yet or the current one has reached its rotation limits.
+// The data key cache is not locked while AWS KMS is called, and concurrent callers needing a
+// new data key for the same context share a single call, so a slow context holds up no other.
+// Every caller sharing a call uses the new data key, so it may be handed out more than the
+// maximum number of times by as many callers as were waiting for it.
+func (kp *AWSKMSKeyProvider) GenerateKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, []byte, error) {
+	contextKey := string(common.CanonicalContext(encryptionContext))
+
+	kp.dataKeyMu.Lock()
+	key := kp.dataKeys[contextKey]
+	if key == nil || kp.expired(key) {
+		kp.dataKeyMu.Unlock()
+
+		var err error
+		if key, err = kp.generateDataKey(ctx, contextKey, encryptionContext); err != nil {
+			return nil, nil, nil, err
+		}
+
+		kp.dataKeyMu.Lock()
+	}
+	key.uses++
+	kp.dataKeyMu.Unlock()
+
+	return key.plaintext[:32], key.plaintext[32:], key.blob, nil
+}
+
+// generateDataKey generates a new data key for the encryption context from AWS KMS, makes it
+// the current one and returns it, unless a concurrent caller already has, in which case that
+// data key is returned instead. Callers for the same context wait for a single call to AWS
+// KMS, or until their own ctx is done.
+func (kp *AWSKMSKeyProvider) generateDataKey(ctx context.Context, contextKey string, encryptionContext map[string]string) (*dataKey, error) {
+	result := kp.generating.DoChan(contextKey, func() (interface{}, error) {
+		kp.dataKeyMu.Lock()
+		current := kp.dataKeys[contextKey]
+		fresh := current != nil && !kp.expired(current)
+		kp.dataKeyMu.Unlock()
+		if fresh {
+			return current, nil
+		}
+
+		// The call is shared, so a caller giving up must not cancel it for the others; its
+		// deadline still applies
+		callCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithDeadline(callCtx, deadline)
+			defer cancel()
+		}
 
-	if kp.isEncKeyExists {
-		resp = kp.encKey
-	} else {
 		// Call AWS KMS API to generate a new data key
-		resp, err = kp.svc.GenerateDataKey(&kms.GenerateDataKeyInput{
-			KeyId:         aws.String(kp.keyId),
-			NumberOfBytes: aws.Int64(64),
+		resp, err := kp.svc.GenerateDataKeyWithContext(callCtx, &kms.GenerateDataKeyInput{
+			KeyId:             aws.String(kp.keyId),
+			NumberOfBytes:     aws.Int64(64),
+			EncryptionContext: aws.StringMap(encryptionContext),
 		})
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 
-		kp.Lock()
-		kp.encKey = resp
-		kp.isEncKeyExists = true
-		kp.Unlock()
+		key := &dataKey{
+			plaintext: resp.Plaintext,
+			blob:      append(append([]byte{}, contextBlobMagic...), resp.CiphertextBlob...),
+			createdAt: time.Now(),
+		}
+		kp.dataKeyMu.Lock()
+		kp.cacheDataKey(contextKey, key)
+		kp.dataKeyMu.Unlock()
+		return key, nil
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*dataKey), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
+
+// expired reports whether a data key has reached its rotation limits.
+func (kp *AWSKMSKeyProvider) expired(key *dataKey) bool {
+	return key.uses >= kp.rotation.maxUses || time.Since(key.createdAt) >= kp.rotation.maxAge
+}
 
-	return resp.Plaintext[:32], resp.Plaintext[32:], resp.CiphertextBlob, nil
+// cacheDataKey records the data key handed out for an encryption context, first making room
+// by dropping expired data keys or, failing that, an arbitrary one. It must be called with
+// dataKeyMu held.
+func (kp *AWSKMSKeyProvider) cacheDataKey(contextKey string, key *dataKey) {
+	if _, ok := kp.dataKeys[contextKey]; !ok && len(kp.dataKeys) >= maxCachedDataKeys {
+		for k, cached := range kp.dataKeys {
+			if kp.expired(cached) {
+				delete(kp.dataKeys, k)
+			}
+		}
+		for k := range kp.dataKeys {
+			if len(kp.dataKeys) < maxCachedDataKeys {
+				break
+			}
+			delete(kp.dataKeys, k)
+		}
+	}
+	kp.dataKeys[contextKey] = key
 }
 
-// RetrieveKey retrieves the encryption key from AWS KMS.
-func (kp *AWSKMSKeyProvider) RetrieveKey(ctBlob []byte) ([]byte, []byte, error) {
+// RetrieveKey retrieves the encryption key from AWS KMS. Blobs written before encryption
+// contexts were supported are decrypted without one.
+func (kp *AWSKMSKeyProvider) RetrieveKey(ctx context.Context, ctBlob []byte, encryptionContext map[string]string) ([]byte, []byte, error) {
 	kp.Lock()
 	defer kp.Unlock()
 
-	key, err := kp.keyCacheStore.Get(string(ctBlob))
+	ciphertext := ctBlob
+	if bytes.HasPrefix(ctBlob, contextBlobMagic) {
+		ciphertext = ctBlob[len(contextBlobMagic):]
+	} else {
+		encryptionContext = nil
+	}
+
+	// The cache is keyed by the context too, so a blob only hits it for the context it is bound to
+	canonical := common.CanonicalContext(encryptionContext)
+	cacheKey := string(binary.AppendUvarint(nil, uint64(len(canonical)))) + string(canonical) + string(ciphertext)
+
+	key, err := kp.keyCacheStore.Get(cacheKey)
 	if err == nil {
 		encKey := key[:32]
 		hmacKey := key[32:]
@@ -91,19 +265,56 @@ func (kp *AWSKMSKeyProvider) RetrieveKey(ctBlob []byte) ([]byte, []byte, error)
 	}
 
 	// Call AWS KMS API to decrypt the encrypted key
-	resp, err := kp.svc.Decrypt(&kms.DecryptInput{
-		CiphertextBlob: ctBlob,
-	})
+	input := &kms.DecryptInput{
+		CiphertextBlob: ciphertext,
+	}
+	if len(encryptionContext) > 0 {
+		input.EncryptionContext = aws.StringMap(encryptionContext)
+	}
+	resp, err := kp.svc.DecryptWithContext(ctx, input)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	key = resp.Plaintext
 
-	err = kp.keyCacheStore.Set(string(ctBlob), key)
+	err = kp.keyCacheStore.Set(cacheKey, key)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	return key[:32], key[32:], nil
 }
+
+// WrapKey encrypts an existing data key under the KMS key, bound to the encryption context.
+// The blob has the same layout as the ones GenerateKey hands out.
+func (kp *AWSKMSKeyProvider) WrapKey(ctx context.Context, encKey, hmacKey []byte, encryptionContext map[string]string) ([]byte, error) {
+	if len(encKey) != 32 || len(hmacKey) != 32 {
+		return nil, fmt.Errorf("data key halves must be 32 bytes each, got %d and %d", len(encKey), len(hmacKey))
+	}
+
+	resp, err := kp.svc.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(kp.keyId),
+		Plaintext:         append(append(make([]byte, 0, 64), encKey...), hmacKey...),
+		EncryptionContext: aws.StringMap(encryptionContext),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, contextBlobMagic...), resp.CiphertextBlob...), nil
+}
+
+// Ping checks that the KMS key exists and is enabled.
+func (kp *AWSKMSKeyProvider) Ping(ctx context.Context) error {
+	resp, err := kp.svc.DescribeKeyWithContext(ctx, &kms.DescribeKeyInput{
+		KeyId: aws.String(kp.keyId),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe kms key: %v", err)
+	}
+	if state := aws.StringValue(resp.KeyMetadata.KeyState); state != kms.KeyStateEnabled {
+		return fmt.Errorf("kms key is %s", state)
+	}
+	return nil
+}
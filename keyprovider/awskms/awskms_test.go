@@ -0,0 +1,271 @@
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// stubKMS counts GenerateDataKey calls and answers each with a distinct data key whose first
+// bytes hold the call number. Calls block while release is set and not yet closed.
+type stubKMS struct {
+	kmsiface.KMSAPI
+
+	calls   atomic.Int64
+	release chan struct{}
+}
+
+func (s *stubKMS) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	call := s.calls.Add(1)
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	plaintext := make([]byte, aws.Int64Value(input.NumberOfBytes))
+	binary.BigEndian.PutUint64(plaintext, uint64(call))
+	return &kms.GenerateDataKeyOutput{
+		Plaintext:      plaintext,
+		CiphertextBlob: []byte(fmt.Sprintf("blob-%d-%s", call, aws.StringValue(input.EncryptionContext["keyPath"]))),
+	}, nil
+}
+
+func newTestProvider(t *testing.T, svc kmsiface.KMSAPI, maxAge time.Duration, maxUses int) *AWSKMSKeyProvider {
+	t.Helper()
+
+	kp, err := newAWSKMSKeyProvider("us-east-1", "key", svc, rotationSettings{maxAge: maxAge, maxUses: maxUses})
+	if err != nil {
+		t.Fatalf("newAWSKMSKeyProvider: %v", err)
+	}
+	return kp
+}
+
+func TestNewAWSKMSKeyProviderRotationLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxAge  time.Duration
+		maxUses int
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "custom limits", maxAge: time.Hour, maxUses: 10},
+		{name: "max age at the minimum", maxAge: MinDataKeyMaxAge},
+		{name: "max age below the minimum", maxAge: time.Nanosecond, wantErr: true},
+		{name: "negative max age", maxAge: -time.Hour, wantErr: true},
+		{name: "negative max uses", maxUses: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAWSKMSKeyProvider("us-east-1", "key", WithDataKeyRotation(tt.maxAge, tt.maxUses))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAWSKMSKeyProvider: got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateKeyRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxAge    time.Duration
+		maxUses   int
+		keys      int
+		wantCalls int64
+	}{
+		{name: "reused within the limits", maxAge: time.Hour, maxUses: 100, keys: 10, wantCalls: 1},
+		{name: "rotated after max uses", maxAge: time.Hour, maxUses: 3, keys: 7, wantCalls: 3},
+		{name: "single use", maxAge: time.Hour, maxUses: 1, keys: 5, wantCalls: 5},
+		// Every data key is already expired when it is handed out, and each call must still return one
+		{name: "tiny max age", maxAge: time.Nanosecond, maxUses: 100, keys: 5, wantCalls: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubKMS{}
+			kp := newTestProvider(t, svc, tt.maxAge, tt.maxUses)
+			encryptionContext := map[string]string{"keyPath": "app/db"}
+
+			for i := 0; i < tt.keys; i++ {
+				encKey, hmacKey, blob, err := kp.GenerateKey(context.Background(), encryptionContext)
+				if err != nil {
+					t.Fatalf("GenerateKey: %v", err)
+				}
+				if len(encKey) != 32 || len(hmacKey) != 32 || !bytes.HasPrefix(blob, contextBlobMagic) {
+					t.Fatalf("GenerateKey: got a %d and %d byte key and blob %q", len(encKey), len(hmacKey), blob)
+				}
+			}
+
+			if calls := svc.calls.Load(); calls != tt.wantCalls {
+				t.Errorf("got %d GenerateDataKey calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGenerateKeyPerEncryptionContext(t *testing.T) {
+	svc := &stubKMS{}
+	kp := newTestProvider(t, svc, time.Hour, 100)
+
+	blobs := map[string][]byte{}
+	for _, keyPath := range []string{"a", "b", "a", "b"} {
+		_, _, blob, err := kp.GenerateKey(context.Background(), map[string]string{"keyPath": keyPath})
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		if previous, ok := blobs[keyPath]; ok && !bytes.Equal(previous, blob) {
+			t.Errorf("%s: got a new data key before reaching the rotation limits", keyPath)
+		}
+		blobs[keyPath] = blob
+	}
+
+	if bytes.Equal(blobs["a"], blobs["b"]) {
+		t.Errorf("two encryption contexts share a data key")
+	}
+	if calls := svc.calls.Load(); calls != 2 {
+		t.Errorf("got %d GenerateDataKey calls, want 2", calls)
+	}
+}
+
+func TestGenerateKeyConcurrent(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxAge  time.Duration
+		maxUses int
+		// maxCalls bounds the GenerateDataKey calls made for the callers.
+		maxCalls int64
+	}{
+		{name: "shared data key", maxAge: time.Hour, maxUses: 1000, maxCalls: 1},
+		{name: "single use", maxAge: time.Hour, maxUses: 1, maxCalls: 50},
+		{name: "tiny max age", maxAge: time.Nanosecond, maxUses: 1000, maxCalls: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const callers = 50
+			svc := &stubKMS{release: make(chan struct{})}
+			kp := newTestProvider(t, svc, tt.maxAge, tt.maxUses)
+			encryptionContext := map[string]string{"keyPath": "app/db"}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, callers)
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, _, _, err := kp.GenerateKey(context.Background(), encryptionContext)
+					errs <- err
+				}()
+			}
+
+			// Let the callers pile up on the first call before it returns
+			time.Sleep(20 * time.Millisecond)
+			close(svc.release)
+
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("GenerateKey did not return for every caller after %d calls", svc.calls.Load())
+			}
+
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Errorf("GenerateKey: %v", err)
+				}
+			}
+			if calls := svc.calls.Load(); calls < 1 || calls > tt.maxCalls {
+				t.Errorf("got %d GenerateDataKey calls for %d callers, want 1 to %d", calls, callers, tt.maxCalls)
+			}
+		})
+	}
+}
+
+func TestGenerateKeyCallerGivesUp(t *testing.T) {
+	svc := &stubKMS{release: make(chan struct{})}
+	kp := newTestProvider(t, svc, time.Hour, 100)
+	encryptionContext := map[string]string{"keyPath": "app/db"}
+
+	// The second caller waits for the same call to AWS KMS and gives up first
+	first := make(chan error, 1)
+	go func() {
+		_, _, _, err := kp.GenerateKey(context.Background(), encryptionContext)
+		first <- err
+	}()
+	for svc.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, err := kp.GenerateKey(ctx, encryptionContext); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateKey: got error %v, want %v", err, context.Canceled)
+	}
+
+	// Giving up does not cancel the call for the first caller
+	close(svc.release)
+	if err := <-first; err != nil {
+		t.Errorf("GenerateKey: %v", err)
+	}
+	if calls := svc.calls.Load(); calls != 1 {
+		t.Errorf("got %d GenerateDataKey calls, want 1", calls)
+	}
+}
+
+func TestCacheDataKeyEviction(t *testing.T) {
+	kp := newTestProvider(t, &stubKMS{}, time.Hour, 2)
+
+	kp.dataKeyMu.Lock()
+	defer kp.dataKeyMu.Unlock()
+
+	// A full cache with one expired data key drops that one to make room
+	for i := 0; i < maxCachedDataKeys; i++ {
+		kp.cacheDataKey(fmt.Sprint(i), &dataKey{createdAt: time.Now()})
+	}
+	kp.dataKeys["7"].uses = 2
+	kp.cacheDataKey("new", &dataKey{createdAt: time.Now()})
+
+	if len(kp.dataKeys) != maxCachedDataKeys {
+		t.Errorf("got %d cached data keys, want %d", len(kp.dataKeys), maxCachedDataKeys)
+	}
+	if _, ok := kp.dataKeys["7"]; ok {
+		t.Errorf("the expired data key is still cached")
+	}
+	if _, ok := kp.dataKeys["new"]; !ok {
+		t.Errorf("the new data key is not cached")
+	}
+
+	// Without expired data keys an arbitrary one makes room
+	kp.cacheDataKey("newer", &dataKey{createdAt: time.Now()})
+	if len(kp.dataKeys) != maxCachedDataKeys {
+		t.Errorf("got %d cached data keys, want %d", len(kp.dataKeys), maxCachedDataKeys)
+	}
+	if _, ok := kp.dataKeys["newer"]; !ok {
+		t.Errorf("the newer data key is not cached")
+	}
+
+	// Replacing the data key of a cached context evicts nothing
+	kp.cacheDataKey("newer", &dataKey{createdAt: time.Now()})
+	if len(kp.dataKeys) != maxCachedDataKeys {
+		t.Errorf("got %d cached data keys, want %d", len(kp.dataKeys), maxCachedDataKeys)
+	}
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// CanonicalContext encodes an encryption context deterministically: its pairs sorted by key,
+// each key and value prefixed by its length. Two contexts encode equally only if they are equal.
+func CanonicalContext(encryptionContext map[string]string) []byte {
+	keys := make([]string, 0, len(encryptionContext))
+	for key := range encryptionContext {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b []byte
+	for _, key := range keys {
+		b = binary.AppendUvarint(b, uint64(len(key)))
+		b = append(b, key...)
+		b = binary.AppendUvarint(b, uint64(len(encryptionContext[key])))
+		b = append(b, encryptionContext[key]...)
+	}
+	return b
+}
@@ -1,16 +1,25 @@
 package keyprovider
 
 import (
+	"context"
 	"fmt"
 	"github.com/ngoyal16/owlvault/config"
 	"github.com/ngoyal16/owlvault/keyprovider/awskms"
+	"github.com/ngoyal16/owlvault/keyprovider/keyring"
 	"github.com/ngoyal16/owlvault/keyprovider/localfile"
 )
 
 type KeyProvider interface {
-	// RetrieveKey retrieves the encryption key.
-	GenerateKey() ([]byte, []byte, []byte, error)
-	RetrieveKey([]byte) ([]byte, []byte, error)
+	// GenerateKey returns a new encryption key, HMAC key and the provider blob needed to retrieve them again.
+	// The blob is bound to encryptionContext: RetrieveKey fails unless it is given the same context.
+	GenerateKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, []byte, error)
+	// RetrieveKey retrieves the encryption and HMAC keys for a provider blob and the encryption context it was generated with.
+	RetrieveKey(ctx context.Context, ctBlob []byte, encryptionContext map[string]string) ([]byte, []byte, error)
+	// WrapKey wraps an existing encryption and HMAC key under the provider's current master key,
+	// returning a blob that RetrieveKey resolves to the same keys given the same encryption context.
+	WrapKey(ctx context.Context, encKey, hmacKey []byte, encryptionContext map[string]string) ([]byte, error)
+	// Ping checks that the provider can currently generate and retrieve keys.
+	Ping(ctx context.Context) error
 }
 
 // KeyProviderType represents the type of key provider.
@@ -21,22 +30,31 @@ const (
 	LOCAL KeyProviderType = "localfile"
 	// AWSKMS represents the AWS KMS key provider solutions.
 	AWSKMS KeyProviderType = "awskms"
+	// KEYRING represents several of the other key providers, one of which wraps new data keys.
+	KEYRING KeyProviderType = "keyring"
 )
 
 // NewKeyProvider initalizes and returns the appropriate  key provider implementation based on the configuration.
 func NewKeyProvider(cfg *config.Config) (KeyProvider, error) {
+	if KeyProviderType(cfg.KeyProvider.Type) == KEYRING {
+		return newKeyring(cfg)
+	}
+	return newKeyProvider(cfg.KeyProvider.Type, cfg.KeyProvider.LocalFile, cfg.KeyProvider.AWSKMS)
+}
+
+// newKeyProvider initializes a single key provider of the given type from its configuration block.
+func newKeyProvider(providerType string, localFileCfg config.LocalFileConfig, awsKMSCfg config.AWSKMSConfig) (KeyProvider, error) {
 	var keyProvider KeyProvider
 	var err error
 
-	keyProviderType := KeyProviderType(cfg.KeyProvider.Type)
-
-	switch keyProviderType {
+	switch KeyProviderType(providerType) {
 	case LOCAL:
-		keyProvider, err = localfile.NewLocalFileKeyProvider(cfg.KeyProvider.LocalFile.Path)
+		keyProvider, err = localfile.NewLocalFileKeyProvider(localFileCfg.Path)
 	case AWSKMS:
-		keyProvider, err = awskms.NewAWSKMSKeyProvider(cfg.KeyProvider.AWSKMS.Region, cfg.KeyProvider.AWSKMS.KeyArn)
+		keyProvider, err = awskms.NewAWSKMSKeyProvider(awsKMSCfg.Region, awsKMSCfg.KeyArn,
+			awskms.WithDataKeyRotation(awsKMSCfg.DataKeyMaxAge, awsKMSCfg.DataKeyMaxUses))
 	default:
-		return nil, fmt.Errorf("unsupported storage type: %s", cfg.KeyProvider.Type)
+		return nil, fmt.Errorf("unsupported key provider type: %s", providerType)
 	}
 
 	if err != nil {
@@ -45,3 +63,33 @@ func NewKeyProvider(cfg *config.Config) (KeyProvider, error) {
 
 	return keyProvider, nil
 }
+
+// newKeyring initializes every key provider of the keyring configuration and the keyring holding them.
+func newKeyring(cfg *config.Config) (KeyProvider, error) {
+	keyringCfg := cfg.KeyProvider.Keyring
+	if len(keyringCfg.Providers) == 0 {
+		return nil, fmt.Errorf("keyring requires at least one key provider")
+	}
+
+	providers := make(map[string]keyring.Provider, len(keyringCfg.Providers))
+	for _, providerCfg := range keyringCfg.Providers {
+		if _, ok := providers[providerCfg.ID]; ok {
+			return nil, fmt.Errorf("keyring holds key provider %q twice", providerCfg.ID)
+		}
+		if KeyProviderType(providerCfg.Type) == KEYRING {
+			return nil, fmt.Errorf("keyring cannot hold another keyring")
+		}
+
+		provider, err := newKeyProvider(providerCfg.Type, providerCfg.LocalFile, providerCfg.AWSKMS)
+		if err != nil {
+			return nil, fmt.Errorf("key provider %q: %v", providerCfg.ID, err)
+		}
+		providers[providerCfg.ID] = provider
+	}
+
+	var opts []keyring.Option
+	if keyringCfg.Untagged != "" {
+		opts = append(opts, keyring.WithUntagged(keyringCfg.Untagged))
+	}
+	return keyring.NewKeyring(keyringCfg.Active, providers, opts...)
+}
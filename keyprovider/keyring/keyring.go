@@ -0,0 +1,161 @@
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+)
+
+// tagMagic prefixes every provider blob handed out by a keyring, followed by the length of the
+// id of the provider that wrapped the data key, the id itself, and that provider's own blob.
+// The trailing digit versions the tag layout.
+var tagMagic = []byte("owlvault-kr1\x00")
+
+// maxIDLength bounds provider ids so that their length fits the single length byte of the tag.
+const maxIDLength = 64
+
+// Provider is a key provider held by the keyring. It has the method set of
+// keyprovider.KeyProvider, which cannot be imported here without an import cycle.
+type Provider interface {
+	GenerateKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, []byte, error)
+	RetrieveKey(ctx context.Context, ctBlob []byte, encryptionContext map[string]string) ([]byte, []byte, error)
+	WrapKey(ctx context.Context, encKey, hmacKey []byte, encryptionContext map[string]string) ([]byte, error)
+	Ping(ctx context.Context) error
+}
+
+// Keyring implements the KeyProvider interface on top of several key providers. New data keys
+// are wrapped by the active provider only, and each provider blob is tagged with the id of the
+// provider that wrapped it, so that existing data stays readable after the active provider
+// changes as long as its provider remains in the keyring.
+type Keyring struct {
+	active    string
+	untagged  string
+	providers map[string]Provider
+}
+
+// Option represents an option for configuring a new Keyring.
+type Option func(*Keyring)
+
+// WithUntagged sets the provider that unwraps blobs stored before the keyring was introduced,
+// which carry no tag. It defaults to the active provider.
+func WithUntagged(id string) Option {
+	return func(kr *Keyring) {
+		kr.untagged = id
+	}
+}
+
+// NewKeyring creates a keyring of the given providers by id, with active wrapping new data keys.
+func NewKeyring(active string, providers map[string]Provider, opts ...Option) (*Keyring, error) {
+	kr := &Keyring{
+		active:    active,
+		untagged:  active,
+		providers: providers,
+	}
+	for _, opt := range opts {
+		opt(kr)
+	}
+
+	for id := range providers {
+		if err := validateID(id); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := providers[kr.active]; !ok {
+		return nil, fmt.Errorf("active key provider %q is not in the keyring", kr.active)
+	}
+	if _, ok := providers[kr.untagged]; !ok {
+		return nil, fmt.Errorf("key provider %q for untagged data keys is not in the keyring", kr.untagged)
+	}
+
+	return kr, nil
+}
+
+// GenerateKey generates a data key with the active provider and tags its blob with the provider's id.
+func (kr *Keyring) GenerateKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, []byte, error) {
+	encKey, hmacKey, blob, err := kr.providers[kr.active].GenerateKey(ctx, encryptionContext)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return encKey, hmacKey, tag(kr.active, blob), nil
+}
+
+// RetrieveKey unwraps a data key with the provider its blob is tagged with, or with the
+// provider for untagged data keys if the blob carries no tag.
+func (kr *Keyring) RetrieveKey(ctx context.Context, ctBlob []byte, encryptionContext map[string]string) ([]byte, []byte, error) {
+	id, blob, err := untag(ctBlob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id == "" {
+		id = kr.untagged
+	}
+
+	provider, ok := kr.providers[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("data key was wrapped by key provider %q, which is not in the keyring", id)
+	}
+	return provider.RetrieveKey(ctx, blob, encryptionContext)
+}
+
+// WrapKey wraps an existing data key with the active provider and tags the blob with its id.
+func (kr *Keyring) WrapKey(ctx context.Context, encKey, hmacKey []byte, encryptionContext map[string]string) ([]byte, error) {
+	blob, err := kr.providers[kr.active].WrapKey(ctx, encKey, hmacKey, encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	return tag(kr.active, blob), nil
+}
+
+// Ping checks every provider in the keyring, since each of them may be needed to read existing data.
+func (kr *Keyring) Ping(ctx context.Context) error {
+	ids := make([]string, 0, len(kr.providers))
+	for id := range kr.providers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if err := kr.providers[id].Ping(ctx); err != nil {
+			return fmt.Errorf("key provider %q: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// tag prefixes a provider blob with the id of the provider that produced it.
+func tag(id string, blob []byte) []byte {
+	tagged := make([]byte, 0, len(tagMagic)+1+len(id)+len(blob))
+	tagged = append(tagged, tagMagic...)
+	tagged = append(tagged, byte(len(id)))
+	tagged = append(tagged, id...)
+	return append(tagged, blob...)
+}
+
+// untag splits a tagged blob into the provider id and the provider's own blob. An untagged
+// blob is returned as it is with an empty id.
+func untag(ctBlob []byte) (string, []byte, error) {
+	if !bytes.HasPrefix(ctBlob, tagMagic) {
+		return "", ctBlob, nil
+	}
+
+	rest := ctBlob[len(tagMagic):]
+	if len(rest) < 1 || int(rest[0]) > len(rest)-1 || rest[0] == 0 {
+		return "", nil, fmt.Errorf("malformed keyring data key tag")
+	}
+	n := int(rest[0])
+	return string(rest[1 : 1+n]), rest[1+n:], nil
+}
+
+// validateID checks that a provider id can be recorded in a tag and read back unambiguously.
+func validateID(id string) error {
+	if id == "" || len(id) > maxIDLength {
+		return fmt.Errorf("key provider id %q must be 1 to %d characters long", id, maxIDLength)
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("key provider id %q may only contain letters, digits, '-', '_' and '.'", id)
+		}
+	}
+	return nil
+}
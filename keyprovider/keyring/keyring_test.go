@@ -0,0 +1,180 @@
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeProvider wraps data keys by prefixing them with its name, so that a blob shows which
+// provider produced it.
+type fakeProvider struct {
+	name string
+}
+
+func (p fakeProvider) GenerateKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, []byte, error) {
+	encKey, hmacKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	blob, err := p.WrapKey(ctx, encKey, hmacKey, encryptionContext)
+	return encKey, hmacKey, blob, err
+}
+
+func (p fakeProvider) RetrieveKey(ctx context.Context, ctBlob []byte, encryptionContext map[string]string) ([]byte, []byte, error) {
+	dataKey, ok := bytes.CutPrefix(ctBlob, []byte(p.name+":"))
+	if !ok || len(dataKey) != 64 {
+		return nil, nil, fmt.Errorf("%s cannot unwrap this blob", p.name)
+	}
+	return dataKey[:32], dataKey[32:], nil
+}
+
+func (p fakeProvider) WrapKey(ctx context.Context, encKey, hmacKey []byte, encryptionContext map[string]string) ([]byte, error) {
+	return append(append([]byte(p.name+":"), encKey...), hmacKey...), nil
+}
+
+func (p fakeProvider) Ping(ctx context.Context) error {
+	return nil
+}
+
+func TestTagUntag(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		blob     []byte
+		wantBlob []byte
+	}{
+		{name: "blob", id: "kms", blob: []byte("ciphertext"), wantBlob: []byte("ciphertext")},
+		{name: "empty blob", id: "local", blob: nil, wantBlob: []byte{}},
+		{name: "longest id", id: strings.Repeat("a", maxIDLength), blob: []byte{0, 1, 2}, wantBlob: []byte{0, 1, 2}},
+		{name: "blob starting like a tag", id: "x", blob: append([]byte(nil), tagMagic...), wantBlob: tagMagic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, blob, err := untag(tag(tt.id, tt.blob))
+			if err != nil {
+				t.Fatalf("untag: %v", err)
+			}
+			if id != tt.id || !bytes.Equal(blob, tt.wantBlob) {
+				t.Errorf("untag: got %q, %q, want %q, %q", id, blob, tt.id, tt.wantBlob)
+			}
+		})
+	}
+}
+
+func TestUntag(t *testing.T) {
+	tagged := func(rest ...byte) []byte {
+		return append(append([]byte(nil), tagMagic...), rest...)
+	}
+
+	tests := []struct {
+		name     string
+		ctBlob   []byte
+		wantID   string
+		wantBlob []byte
+		wantErr  bool
+	}{
+		{name: "untagged", ctBlob: []byte("legacy blob"), wantBlob: []byte("legacy blob")},
+		{name: "partial magic is untagged", ctBlob: tagMagic[:len(tagMagic)-1], wantBlob: tagMagic[:len(tagMagic)-1]},
+		{name: "tagged", ctBlob: tagged(2, 'i', 'd', 'x'), wantID: "id", wantBlob: []byte("x")},
+		{name: "missing id length", ctBlob: tagged(), wantErr: true},
+		{name: "empty id", ctBlob: tagged(0, 'x'), wantErr: true},
+		{name: "id longer than the blob", ctBlob: tagged(5, 'i', 'd'), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, blob, err := untag(tt.ctBlob)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("untag: got %q, %q, want an error", id, blob)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("untag: %v", err)
+			}
+			if id != tt.wantID || !bytes.Equal(blob, tt.wantBlob) {
+				t.Errorf("untag: got %q, %q, want %q, %q", id, blob, tt.wantID, tt.wantBlob)
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	providers := map[string]Provider{"old": fakeProvider{"old"}, "new": fakeProvider{"new"}}
+
+	tests := []struct {
+		name      string
+		active    string
+		providers map[string]Provider
+		opts      []Option
+		wantErr   bool
+	}{
+		{name: "valid", active: "new", providers: providers},
+		{name: "untagged provider", active: "new", providers: providers, opts: []Option{WithUntagged("old")}},
+		{name: "unknown active provider", active: "other", providers: providers, wantErr: true},
+		{name: "unknown untagged provider", active: "new", providers: providers, opts: []Option{WithUntagged("other")}, wantErr: true},
+		{name: "invalid id", active: "a b", providers: map[string]Provider{"a b": fakeProvider{"a b"}}, wantErr: true},
+		{name: "id too long", active: strings.Repeat("a", maxIDLength+1), providers: map[string]Provider{strings.Repeat("a", maxIDLength+1): fakeProvider{"a"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.active, tt.providers, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewKeyring: got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	old, current := fakeProvider{"old"}, fakeProvider{"new"}
+
+	kr, err := NewKeyring("new", map[string]Provider{"old": old, "new": current}, WithUntagged("old"))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	_, _, legacyBlob, _ := old.GenerateKey(ctx, nil)
+	encKey, hmacKey, newBlob, err := kr.GenerateKey(ctx, nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	wrappedBlob, err := kr.WrapKey(ctx, encKey, hmacKey, nil)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ctBlob  []byte
+		wantErr bool
+	}{
+		{name: "generated by the active provider", ctBlob: newBlob},
+		{name: "wrapped by the active provider", ctBlob: wrappedBlob},
+		{name: "tagged with a previous provider", ctBlob: tag("old", legacyBlob)},
+		{name: "untagged", ctBlob: legacyBlob},
+		{name: "tagged with a provider not in the keyring", ctBlob: tag("gone", legacyBlob), wantErr: true},
+		{name: "malformed tag", ctBlob: append(append([]byte(nil), tagMagic...), 0), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotEncKey, gotHMACKey, err := kr.RetrieveKey(ctx, tt.ctBlob, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("RetrieveKey: succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RetrieveKey: %v", err)
+			}
+			if !bytes.Equal(gotEncKey, encKey) || !bytes.Equal(gotHMACKey, hmacKey) {
+				t.Errorf("RetrieveKey: got a different data key")
+			}
+		})
+	}
+}
@@ -1,26 +1,203 @@
 package localfile
 
-// LocalFileKeyProvider implements the KeyProvider interface for retrieving keys from a local file.
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/ngoyal16/owlvault/keyprovider/common"
+)
+
+// masterKeySize is the size of the AES-256 master key held in the key file.
+const masterKeySize = 32
+
+// dataKeySize is the size of a data key: a 32-byte encryption key followed by a 32-byte HMAC key.
+const dataKeySize = 64
+
+// Versions of the wrapped data key layout: the version byte, the GCM nonce, then the sealed
+// data key. The version byte is authenticated as additional data, followed for
+// blobVersionContext by the canonical encryption context.
+const (
+	blobVersionLegacy  byte = 1
+	blobVersionContext byte = 2
+)
+
+// LocalFileKeyProvider implements the KeyProvider interface with a master key kept in a local
+// file. Every generated data key is random and is returned wrapped by the master key with
+// AES-256-GCM, so the provider blob stored as kp_id is useless without the key file.
 type LocalFileKeyProvider struct {
 	filePath string
+
+	aead      cipher.AEAD
+	masterKey []byte
 }
 
+// NewLocalFileKeyProvider loads the master key from filePath, creating the file with a new
+// random key if it does not exist. The file must not be accessible by group or others.
 func NewLocalFileKeyProvider(filePath string) (*LocalFileKeyProvider, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("a master key file path is required")
+	}
+
+	masterKey, err := readMasterKey(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		masterKey, err = createMasterKey(filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
 	return &LocalFileKeyProvider{
-		filePath: filePath,
+		filePath:  filePath,
+		aead:      aead,
+		masterKey: masterKey,
 	}, nil
 }
 
-// GenerateKey retrieves the encryption key from a local file.
-func (kp *LocalFileKeyProvider) GenerateKey() ([]byte, []byte, []byte, error) {
-	// Implement logic to read the key from the local file
+// GenerateKey generates a random data key and returns its encryption and HMAC halves with
+// the data key wrapped by the master key and bound to the encryption context.
+func (kp *LocalFileKeyProvider) GenerateKey(ctx context.Context, encryptionContext map[string]string) ([]byte, []byte, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+
+	blob, err := kp.wrap(dataKey, encryptionContext)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return dataKey[:32], dataKey[32:], blob, nil
+}
+
+// WrapKey wraps an existing data key with the master key, bound to the encryption context.
+func (kp *LocalFileKeyProvider) WrapKey(ctx context.Context, encKey, hmacKey []byte, encryptionContext map[string]string) ([]byte, error) {
+	if len(encKey) != 32 || len(hmacKey) != 32 {
+		return nil, fmt.Errorf("data key halves must be 32 bytes each, got %d and %d", len(encKey), len(hmacKey))
+	}
+	return kp.wrap(append(append(make([]byte, 0, dataKeySize), encKey...), hmacKey...), encryptionContext)
+}
 
-	return nil, nil, nil, nil
+// wrap seals a data key into a blob of the current version under a fresh nonce.
+func (kp *LocalFileKeyProvider) wrap(dataKey []byte, encryptionContext map[string]string) ([]byte, error) {
+	blob := make([]byte, 1+kp.aead.NonceSize(), 1+kp.aead.NonceSize()+dataKeySize+kp.aead.Overhead())
+	blob[0] = blobVersionContext
+	if _, err := io.ReadFull(rand.Reader, blob[1:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return kp.aead.Seal(blob, blob[1:], dataKey, additionalData(blobVersionContext, encryptionContext)), nil
 }
 
-// RetrieveKey retrieves the decryption key from a local file.
-func (kp *LocalFileKeyProvider) RetrieveKey(ctBlob []byte) ([]byte, []byte, error) {
-	// Implement logic to read the key from the local file
+// RetrieveKey unwraps a data key produced by GenerateKey and returns its encryption and HMAC
+// halves. Blobs written before encryption contexts were supported are not bound to one.
+func (kp *LocalFileKeyProvider) RetrieveKey(ctx context.Context, ctBlob []byte, encryptionContext map[string]string) ([]byte, []byte, error) {
+	nonceSize := kp.aead.NonceSize()
+	if len(ctBlob) < 1+nonceSize || (ctBlob[0] != blobVersionLegacy && ctBlob[0] != blobVersionContext) {
+		return nil, nil, fmt.Errorf("unsupported data key blob")
+	}
+
+	dataKey, err := kp.aead.Open(nil, ctBlob[1:1+nonceSize], ctBlob[1+nonceSize:], additionalData(ctBlob[0], encryptionContext))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap data key, it was wrapped by another master key or for another encryption context, or is corrupt: %v", err)
+	}
+	if len(dataKey) != dataKeySize {
+		return nil, nil, fmt.Errorf("unwrapped data key is %d bytes, expected %d", len(dataKey), dataKeySize)
+	}
+
+	return dataKey[:32], dataKey[32:], nil
+}
+
+// Ping checks that the key file still holds the master key in use. A missing or replaced
+// file would leave a restarted instance unable to read the stored data.
+func (kp *LocalFileKeyProvider) Ping(ctx context.Context) error {
+	masterKey, err := readMasterKey(kp.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("master key file %s is missing", kp.filePath)
+	}
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(masterKey, kp.masterKey) != 1 {
+		return fmt.Errorf("master key file %s no longer holds the master key in use", kp.filePath)
+	}
+	return nil
+}
+
+// additionalData is the data authenticated alongside a data key wrapped in the given blob version.
+func additionalData(version byte, encryptionContext map[string]string) []byte {
+	if version == blobVersionLegacy {
+		return []byte{version}
+	}
+	return append([]byte{version}, common.CanonicalContext(encryptionContext)...)
+}
+
+// readMasterKey reads the master key from path after checking the file's permissions.
+func readMasterKey(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("master key file %s is not a regular file", path)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("master key file %s must not be accessible by group or others, has mode %04o", path, perm)
+	}
+
+	masterKey, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %v", err)
+	}
+	if len(masterKey) != masterKeySize {
+		return nil, fmt.Errorf("master key file %s holds %d bytes, expected %d", path, len(masterKey), masterKeySize)
+	}
+	return masterKey, nil
+}
+
+// createMasterKey writes a new random master key to path, which must not exist yet.
+func createMasterKey(path string) ([]byte, error) {
+	masterKey := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, masterKey); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %v", err)
+	}
+
+	// O_EXCL keeps two instances starting at once from each writing their own key
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return readMasterKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create master key file: %v", err)
+	}
+
+	_, err = f.Write(masterKey)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write master key file: %v", err)
+	}
 
-	return nil, nil, nil
+	log.Printf("localfile: created a new master key at %s; back it up, data stored with it cannot be read without it", path)
+	return masterKey, nil
 }
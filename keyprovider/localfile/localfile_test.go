@@ -0,0 +1,126 @@
+package localfile
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrapUnwrapRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "master.key")
+
+	kp, err := NewLocalFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("NewLocalFileKeyProvider: %v", err)
+	}
+	encryptionContext := map[string]string{"keyPath": "app/db"}
+
+	encKey, hmacKey, generated, err := kp.GenerateKey(ctx, encryptionContext)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	wrapped, err := kp.WrapKey(ctx, encKey, hmacKey, encryptionContext)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	if bytes.Equal(generated, wrapped) {
+		t.Errorf("WrapKey: got the same blob as GenerateKey, want a fresh nonce")
+	}
+
+	// A provider loading the same key file must read the blobs of the first one
+	reloaded, err := NewLocalFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("NewLocalFileKeyProvider: %v", err)
+	}
+	other, err := NewLocalFileKeyProvider(filepath.Join(t.TempDir(), "other.key"))
+	if err != nil {
+		t.Fatalf("NewLocalFileKeyProvider: %v", err)
+	}
+
+	corrupt := append([]byte(nil), generated...)
+	corrupt[len(corrupt)-1] ^= 1
+
+	tests := []struct {
+		name              string
+		kp                *LocalFileKeyProvider
+		ctBlob            []byte
+		encryptionContext map[string]string
+		wantErr           bool
+	}{
+		{name: "generated", kp: kp, ctBlob: generated, encryptionContext: encryptionContext},
+		{name: "wrapped", kp: kp, ctBlob: wrapped, encryptionContext: encryptionContext},
+		{name: "reloaded key file", kp: reloaded, ctBlob: generated, encryptionContext: encryptionContext},
+		{name: "other master key", kp: other, ctBlob: generated, encryptionContext: encryptionContext, wantErr: true},
+		{name: "other encryption context", kp: kp, ctBlob: generated, encryptionContext: map[string]string{"keyPath": "app/smtp"}, wantErr: true},
+		{name: "no encryption context", kp: kp, ctBlob: generated, wantErr: true},
+		{name: "corrupt blob", kp: kp, ctBlob: corrupt, encryptionContext: encryptionContext, wantErr: true},
+		{name: "truncated blob", kp: kp, ctBlob: generated[:5], encryptionContext: encryptionContext, wantErr: true},
+		{name: "unknown blob version", kp: kp, ctBlob: append([]byte{9}, generated[1:]...), encryptionContext: encryptionContext, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotEncKey, gotHMACKey, err := tt.kp.RetrieveKey(ctx, tt.ctBlob, tt.encryptionContext)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("RetrieveKey: succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RetrieveKey: %v", err)
+			}
+			if !bytes.Equal(gotEncKey, encKey) || !bytes.Equal(gotHMACKey, hmacKey) {
+				t.Errorf("RetrieveKey: got a different data key")
+			}
+		})
+	}
+}
+
+func TestMasterKeyFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(path string) error
+		wantErr bool
+	}{
+		{name: "created when missing", setup: func(path string) error { return nil }},
+		{name: "existing key", setup: func(path string) error { return os.WriteFile(path, bytes.Repeat([]byte{7}, masterKeySize), 0600) }},
+		{name: "readable by others", setup: func(path string) error { return os.WriteFile(path, bytes.Repeat([]byte{7}, masterKeySize), 0644) }, wantErr: true},
+		{name: "wrong size", setup: func(path string) error { return os.WriteFile(path, []byte("short"), 0600) }, wantErr: true},
+		{name: "not a regular file", setup: func(path string) error { return os.Mkdir(path, 0700) }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "master.key")
+			if err := tt.setup(path); err != nil {
+				t.Fatalf("setup: %v", err)
+			}
+
+			kp, err := NewLocalFileKeyProvider(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("NewLocalFileKeyProvider: succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewLocalFileKeyProvider: %v", err)
+			}
+			if err := kp.Ping(context.Background()); err != nil {
+				t.Errorf("Ping: %v", err)
+			}
+
+			// Ping notices the key file being replaced underneath the running provider
+			if err := os.WriteFile(path, bytes.Repeat([]byte{8}, masterKeySize), 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			if err := kp.Ping(context.Background()); err == nil {
+				t.Errorf("Ping: succeeded after the master key file changed")
+			}
+		})
+	}
+}
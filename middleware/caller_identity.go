@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ngoyal16/owlvault/vault"
+)
+
+// CallerIdentityMiddleware records the value of the given request header as the caller identity,
+// which is stored as the creator of new key versions. Any client can set a header, so it is only
+// read from requests whose direct peer is one of the trusted proxies, given as IP addresses or
+// CIDR ranges, which are expected to authenticate the caller and set the header themselves.
+// It does nothing when header is empty, and fails when header is set without trusted proxies.
+func CallerIdentityMiddleware(header string, trustedProxies []string) (gin.HandlerFunc, error) {
+	if header == "" {
+		return func(c *gin.Context) {
+			c.Next()
+		}, nil
+	}
+
+	if len(trustedProxies) == 0 {
+		return nil, fmt.Errorf("caller identity header %s requires the trusted proxies that set it", header)
+	}
+	trusted, err := parseTrustedProxies(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		if caller := c.GetHeader(header); caller != "" && fromTrustedProxy(c.Request.RemoteAddr, trusted) {
+			c.Request = c.Request.WithContext(vault.ContextWithCaller(c.Request.Context(), caller))
+		}
+
+		c.Next()
+	}, nil
+}
+
+// parseTrustedProxies parses IP addresses and CIDR ranges into networks.
+func parseTrustedProxies(trustedProxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an IP address or CIDR range", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an IP address or CIDR range", proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// fromTrustedProxy reports whether the direct peer of a request is a trusted proxy. Forwarding
+// headers are deliberately ignored, since they can be set by any client too.
+func fromTrustedProxy(remoteAddr string, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
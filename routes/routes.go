@@ -1,12 +1,14 @@
 package routes
 
 import (
+	"context"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ngoyal16/owlvault/config"
+	"github.com/ngoyal16/owlvault/controllers/health"
 	"github.com/ngoyal16/owlvault/controllers/ks2"
 	"github.com/ngoyal16/owlvault/encrypt"
 	"github.com/ngoyal16/owlvault/keyprovider"
@@ -18,7 +20,7 @@ import (
 func GinEngine(cfg *config.Config) *gin.Engine {
 
 	// Initialize storage based on configuration
-	dbStorage, err := storage.NewStorage(cfg)
+	dbStorage, err := storage.NewStorage(context.Background(), cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
@@ -35,8 +37,34 @@ func GinEngine(cfg *config.Config) *gin.Engine {
 		log.Fatalf("Failed to initialize encryptor: %v", err)
 	}
 
+	retention := vault.Retention{
+		Default: vault.RetentionPolicy{
+			MaxVersions: cfg.Retention.MaxVersions,
+			MaxAge:      cfg.Retention.MaxAge,
+		},
+	}
+	for _, prefix := range cfg.Retention.Prefixes {
+		retention.Rules = append(retention.Rules, vault.RetentionRule{
+			Prefix: prefix.Prefix,
+			RetentionPolicy: vault.RetentionPolicy{
+				MaxVersions: prefix.MaxVersions,
+				MaxAge:      prefix.MaxAge,
+			},
+		})
+	}
+
 	// Initialize OwlVault with the chosen storage implementation
-	owlVault := vault.NewOwlVault(dbStorage, keyProvider, encryptor)
+	owlVault := vault.NewOwlVault(dbStorage, keyProvider, encryptor,
+		vault.WithTimeouts(vault.Timeouts{
+			StorageRead:  cfg.Timeouts.StorageRead,
+			StorageWrite: cfg.Timeouts.StorageWrite,
+			KeyProvider:  cfg.Timeouts.KeyProvider,
+		}),
+		vault.WithComponentTypes(cfg.Encryptor.Type, cfg.KeyProvider.Type),
+		vault.WithRetention(retention),
+		vault.WithTenant(cfg.Server.Tenant),
+	)
+	owlVault.StartPruner(context.Background(), cfg.Retention.PruneInterval)
 
 	// Create a new Gorilla Mux router
 	r := gin.Default()
@@ -47,8 +75,16 @@ func GinEngine(cfg *config.Config) *gin.Engine {
 		})
 		return
 	})
+	r.GET("/healthz", health.Liveness())
+	r.GET("/readyz", health.Readiness(owlVault))
+
+	callerIdentity, err := middleware.CallerIdentityMiddleware(cfg.Server.CallerIdentityHeader, cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to initialize caller identity: %v", err)
+	}
 
 	r.Use(middleware.CORSMiddleware())
+	r.Use(callerIdentity)
 
 	v1 := r.Group("v1")
 	{
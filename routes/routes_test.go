@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ngoyal16/owlvault/config"
+)
+
+// newTestConfig configures the memory storage, a local file key provider in a temporary
+// directory and the AES encryptor, so that the engine needs no external service.
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.Storage.Type = "memory"
+	cfg.KeyProvider.Type = "localfile"
+	cfg.KeyProvider.LocalFile.Path = filepath.Join(t.TempDir(), "master.key")
+	cfg.Encryptor.Type = "aes"
+	return cfg
+}
+
+func TestGinEngineKS2Actions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := GinEngine(newTestConfig(t))
+
+	// Each step runs against the state left by the previous ones; want lists fragments of
+	// the compacted JSON response
+	steps := []struct {
+		method   string
+		path     string
+		body     string
+		wantCode int
+		want     []string
+	}{
+		{method: http.MethodGet, path: "/healthz", wantCode: http.StatusOK, want: []string{`"status":"ok"`}},
+		{method: http.MethodGet, path: "/readyz", wantCode: http.StatusOK, want: []string{`"storage":{"status":"ok","latencyMs":`, `"keyProvider":{"status":"ok","latencyMs":`}},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=StoreKey",
+			body:     `{"keyPath": "app/db", "data": {"password": "one"}}`,
+			wantCode: http.StatusOK, want: []string{`"keyPath":"app/db"`, `"version":1`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=StoreKey",
+			body:     `{"keyPath": "app/db", "data": {"password": "two"}, "expectedVersion": 1}`,
+			wantCode: http.StatusOK, want: []string{`"version":2`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=StoreKey",
+			body:     `{"keyPath": "app/db", "data": {"password": "three"}, "expectedVersion": 1}`,
+			wantCode: http.StatusConflict, want: []string{`"code":"VersionMismatch"`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=StoreKey",
+			body:     `{"data": {"password": "one"}}`,
+			wantCode: http.StatusBadRequest, want: []string{`"code":"InvalidInput"`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=StoreKeys",
+			body:     `{"keysToStore": [{"keyPath": "app/smtp", "data": {"user": "mail"}}, {"keyPath": "app/cache", "data": {"url": "redis"}}], "atomic": true}`,
+			wantCode: http.StatusOK, want: []string{`"keyPath":"app/smtp","version":1`, `"keyPath":"app/cache","version":1`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=RetrieveKey",
+			body:     `{"keyPath": "app/db"}`,
+			wantCode: http.StatusOK, want: []string{`"data":{"password":"two"}`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=RetrieveKey",
+			body:     `{"keyPath": "app/db", "version": 1}`,
+			wantCode: http.StatusOK, want: []string{`"data":{"password":"one"}`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=RetrieveKeys",
+			body:     `{"keysToRetrieve": [{"keyPath": "app/smtp"}, {"keyPath": "missing"}]}`,
+			wantCode: http.StatusOK, want: []string{`"data":{"user":"mail"}`, `"code":"InvalidKey.KeyNotFound"`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=ListKeys",
+			body:     `{"prefix": "app/", "pageSize": 2}`,
+			wantCode: http.StatusOK, want: []string{`"keyPath":"app/cache","latestVersion":1`, `"keyPath":"app/db","latestVersion":2`, `"continuationToken":`},
+		},
+		{
+			method: http.MethodGet, path: "/v1/ks2?Action=ListKeys&prefix=app/s",
+			wantCode: http.StatusOK, want: []string{`"keyPath":"app/smtp","latestVersion":1`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=DeleteKey",
+			body:     `{"keyPath": "app/db", "versions": [1]}`,
+			wantCode: http.StatusOK, want: []string{`"versions":[1]`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=RetrieveKey",
+			body:     `{"keyPath": "app/db", "version": 1}`,
+			wantCode: http.StatusOK, want: []string{`"code":"InvalidKey.KeyDeleted"`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=UndeleteKey",
+			body:     `{"keyPath": "app/db", "versions": [1]}`,
+			wantCode: http.StatusOK, want: []string{`"versions":[1]`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=DestroyKeyVersion",
+			body:     `{"keyPath": "app/db", "versions": [1]}`,
+			wantCode: http.StatusOK, want: []string{`"versions":[1]`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=UndeleteKey",
+			body:     `{"keyPath": "app/db", "versions": [1]}`,
+			wantCode: http.StatusUnprocessableEntity, want: []string{`"code":"InvalidKey.KeyDestroyed"`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=DestroyKeyVersion",
+			body:     `{"keyPath": "app/db", "versions": [2, 3]}`,
+			wantCode: http.StatusUnprocessableEntity, want: []string{`"code":"InvalidKey.KeyNotFound"`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=DescribeKey",
+			body:     `{"keyPath": "app/db"}`,
+			wantCode: http.StatusOK, want: []string{`"latestVersion":2`, `"encryptor":"aes"`, `"keyProvider":"localfile"`, `"destroyedAt":`},
+		},
+		{
+			method: http.MethodPost, path: "/v1/ks2?Action=Unknown",
+			wantCode: http.StatusBadRequest, want: []string{`"code":"InvalidAction"`},
+		},
+	}
+
+	for _, step := range steps {
+		request := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		if step.body != "" {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+
+		var body bytes.Buffer
+		if err := json.Compact(&body, recorder.Body.Bytes()); err != nil {
+			t.Fatalf("%s %s: response is not JSON: %v: %s", step.method, step.path, err, recorder.Body)
+		}
+		if recorder.Code != step.wantCode {
+			t.Errorf("%s %s: got status %d, want %d: %s", step.method, step.path, recorder.Code, step.wantCode, body.String())
+		}
+		for _, want := range step.want {
+			if !strings.Contains(body.String(), want) {
+				t.Errorf("%s %s: response %s does not contain %s", step.method, step.path, body.String(), want)
+			}
+		}
+	}
+}
+
+func TestGinEngineCallerIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := newTestConfig(t)
+	cfg.Server.CallerIdentityHeader = "X-Owlvault-Caller"
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	engine := GinEngine(cfg)
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		wantCreatedBy bool
+	}{
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:5000", wantCreatedBy: true},
+		{name: "other peer", remoteAddr: "192.0.2.1:5000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyPath := strings.ReplaceAll(tt.name, " ", "-")
+
+			request := httptest.NewRequest(http.MethodPost, "/v1/ks2?Action=StoreKey",
+				strings.NewReader(`{"keyPath": "`+keyPath+`", "data": {"k": "v"}}`))
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("X-Owlvault-Caller", "deploy-bot")
+			request.RemoteAddr = tt.remoteAddr
+			engine.ServeHTTP(httptest.NewRecorder(), request)
+
+			request = httptest.NewRequest(http.MethodPost, "/v1/ks2?Action=DescribeKey",
+				strings.NewReader(`{"keyPath": "`+keyPath+`"}`))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+
+			var body bytes.Buffer
+			if err := json.Compact(&body, recorder.Body.Bytes()); err != nil {
+				t.Fatalf("response is not JSON: %v: %s", err, recorder.Body)
+			}
+			if got := strings.Contains(body.String(), `"createdBy":"deploy-bot"`); got != tt.wantCreatedBy {
+				t.Errorf("got createdBy recorded %t, want %t: %s", got, tt.wantCreatedBy, body.String())
+			}
+		})
+	}
+}
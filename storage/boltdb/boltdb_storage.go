@@ -0,0 +1,562 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+// kvStoreBucket is the top-level bucket holding one nested bucket per key path.
+var kvStoreBucket = []byte("kv_store")
+
+// BoltDBStorage implements the Storage interface on an embedded bbolt database file.
+// Each key path gets its own nested bucket keyed by big-endian version numbers,
+// so bbolt's byte ordering keeps versions sorted.
+type BoltDBStorage struct {
+	db *bolt.DB
+}
+
+// kvRecord is the value stored for a single version.
+type kvRecord struct {
+	Contents string `json:"contents"`
+	HMAC     string `json:"hmac"`
+	KPID     string `json:"kp_id"`
+
+	CreatedAt   time.Time `json:"created_at"`
+	Encryptor   string    `json:"encryptor,omitempty"`
+	KeyProvider string    `json:"key_provider,omitempty"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	DestroyedAt *time.Time `json:"destroyed_at,omitempty"`
+	PrunedAt    *time.Time `json:"pruned_at,omitempty"`
+}
+
+// NewBoltDBStorage creates a new instance of BoltDBStorage backed by the file at path.
+func NewBoltDBStorage(path string) (*BoltDBStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	return &BoltDBStorage{db: db}, nil
+}
+
+// Migrate creates the top-level bucket if it does not exist yet.
+func (b *BoltDBStorage) Migrate(ctx context.Context) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(kvStoreBucket)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to run migrations: %v", err)
+	}
+	return nil
+}
+
+// Ping checks that the database is open and migrated.
+func (b *BoltDBStorage) Ping(ctx context.Context) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		_, err := kvBucket(tx)
+		return err
+	})
+}
+
+// Store stores the record with its metadata.
+func (b *BoltDBStorage) Store(ctx context.Context, record common.Record) error {
+	value, err := json.Marshal(kvRecord{
+		Contents:    record.Contents,
+		HMAC:        record.HMAC,
+		KPID:        record.KPId,
+		CreatedAt:   record.Metadata.CreatedAt.UTC(),
+		Encryptor:   record.Metadata.Encryptor,
+		KeyProvider: record.Metadata.KeyProvider,
+		CreatedBy:   record.Metadata.CreatedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket, err := kv.CreateBucketIfNotExists([]byte(record.KeyPath))
+		if err != nil {
+			return fmt.Errorf("failed to create key path bucket: %v", err)
+		}
+
+		// Update transactions are serialised, so this check cannot race
+		if bucket.Get(versionKey(record.Version)) != nil {
+			return common.ErrVersionConflict
+		}
+		return bucket.Put(versionKey(record.Version), value)
+	})
+}
+
+// Retrieve retrieves the value for the specified key and version.
+func (b *BoltDBStorage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	var record kvRecord
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := kv.Bucket([]byte(keyPath))
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get(versionKey(version))
+		if value == nil {
+			return nil
+		}
+		return json.Unmarshal(value, &record)
+	})
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to retrieve item: %v", err)
+	}
+	if err := common.StateError(record.DeletedAt, record.DestroyedAt); err != nil {
+		return "", "", "", err
+	}
+
+	return record.Contents, record.HMAC, record.KPID, nil
+}
+
+// LatestVersion returns the latest version of the value for the specified key.
+func (b *BoltDBStorage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	var version int
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := kv.Bucket([]byte(keyPath))
+		if bucket == nil {
+			return nil
+		}
+
+		k, _ := bucket.Cursor().Last()
+		if k != nil {
+			version = int(binary.BigEndian.Uint64(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// BatchStore stores the records in a single write transaction.
+func (b *BoltDBStorage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	return b.batchStore(records, false)
+}
+
+// batchStore writes the records in one transaction, skipping conflicting versions or,
+// when atomic is set, rolling back on the first one.
+func (b *BoltDBStorage) batchStore(records []common.Record, atomic bool) ([]error, error) {
+	values := make([][]byte, len(records))
+	for i, record := range records {
+		value, err := json.Marshal(kvRecord{
+			Contents:    record.Contents,
+			HMAC:        record.HMAC,
+			KPID:        record.KPId,
+			CreatedAt:   record.Metadata.CreatedAt.UTC(),
+			Encryptor:   record.Metadata.Encryptor,
+			KeyProvider: record.Metadata.KeyProvider,
+			CreatedBy:   record.Metadata.CreatedBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	errs := make([]error, len(records))
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		for i, record := range records {
+			bucket, err := kv.CreateBucketIfNotExists([]byte(record.KeyPath))
+			if err != nil {
+				return fmt.Errorf("failed to create key path bucket: %v", err)
+			}
+
+			if bucket.Get(versionKey(record.Version)) != nil {
+				if atomic {
+					return common.ErrVersionConflict
+				}
+				errs[i] = common.ErrVersionConflict
+				continue
+			}
+			if err := bucket.Put(versionKey(record.Version), values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// StoreAtomic stores the records in a single write transaction that is rolled back if any version already exists.
+func (b *BoltDBStorage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	errs, err := b.batchStore(records, true)
+	if err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchRetrieve retrieves the versions in a single read transaction.
+func (b *BoltDBStorage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	results := make([]common.RetrieveResult, len(keys))
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		for i, key := range keys {
+			bucket := kv.Bucket([]byte(key.KeyPath))
+			if bucket == nil {
+				continue
+			}
+
+			value := bucket.Get(versionKey(key.Version))
+			if value == nil {
+				continue
+			}
+
+			var record kvRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				results[i].Err = fmt.Errorf("failed to retrieve item: %v", err)
+				continue
+			}
+			if err := common.StateError(record.DeletedAt, record.DestroyedAt); err != nil {
+				results[i].Err = err
+				continue
+			}
+			results[i] = common.RetrieveResult{Contents: record.Contents, HMAC: record.HMAC, KPId: record.KPID}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// BatchLatestVersion returns the latest version of each key path in a single read transaction.
+func (b *BoltDBStorage) BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	latest := make(map[string]int, len(keyPaths))
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, keyPath := range keyPaths {
+			bucket := kv.Bucket([]byte(keyPath))
+			if bucket == nil {
+				continue
+			}
+
+			if k, _ := bucket.Cursor().Last(); k != nil {
+				latest[keyPath] = int(binary.BigEndian.Uint64(k))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return latest, nil
+}
+
+// Versions describes every stored version of the key path in ascending order.
+func (b *BoltDBStorage) Versions(ctx context.Context, keyPath string) ([]common.VersionInfo, error) {
+	var versions []common.VersionInfo
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := kv.Bucket([]byte(keyPath))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var record kvRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			versions = append(versions, record.versionInfo(int(binary.BigEndian.Uint64(k))))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions: %v", err)
+	}
+
+	return versions, nil
+}
+
+// Export returns every stored version of the key path in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (b *BoltDBStorage) Export(ctx context.Context, keyPath string) ([]common.Record, error) {
+	var records []common.Record
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := kv.Bucket([]byte(keyPath))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var record kvRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			info := record.versionInfo(int(binary.BigEndian.Uint64(k)))
+			records = append(records, common.Record{
+				KeyPath:  keyPath,
+				Version:  info.Version,
+				Contents: record.Contents,
+				HMAC:     record.HMAC,
+				KPId:     record.KPID,
+				Metadata: info.Metadata,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions: %v", err)
+	}
+
+	return records, nil
+}
+
+// List returns key paths starting with prefix in key path order, with their latest versions.
+func (b *BoltDBStorage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	after, err := common.DecodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var summaries []common.KeySummary
+
+	err = b.db.View(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := kv.Cursor()
+
+		start := []byte(prefix)
+		if after >= prefix {
+			start = append([]byte(after), 0)
+		}
+
+		for k, _ := cursor.Seek(start); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = cursor.Next() {
+			bucket := kv.Bucket(k)
+			if bucket == nil {
+				continue
+			}
+
+			summary := common.KeySummary{KeyPath: string(k)}
+			if last, _ := bucket.Cursor().Last(); last != nil {
+				summary.LatestVersion = int(binary.BigEndian.Uint64(last))
+			}
+			summaries = append(summaries, summary)
+
+			if len(summaries) > limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	summaries, next := common.Page(summaries, limit)
+	return summaries, next, nil
+}
+
+// Delete soft-deletes the specified version.
+func (b *BoltDBStorage) Delete(ctx context.Context, keyPath string, version int) error {
+	return b.update(keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if record.DeletedAt == nil {
+			now := time.Now().UTC()
+			record.DeletedAt = &now
+		}
+		return nil
+	})
+}
+
+// Undelete recovers a soft-deleted version.
+func (b *BoltDBStorage) Undelete(ctx context.Context, keyPath string, version int) error {
+	return b.update(keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		record.DeletedAt = nil
+		return nil
+	})
+}
+
+// Destroy permanently erases the contents of the specified version.
+func (b *BoltDBStorage) Destroy(ctx context.Context, keyPath string, version int) error {
+	return b.update(keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt == nil {
+			now := time.Now().UTC()
+			record.DestroyedAt = &now
+		}
+		record.Contents = ""
+		record.HMAC = ""
+		record.KPID = ""
+		return nil
+	})
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (b *BoltDBStorage) Prune(ctx context.Context, keyPath string, version int) error {
+	return b.update(keyPath, version, func(record *kvRecord) error {
+		now := time.Now().UTC()
+		if record.DestroyedAt == nil {
+			record.DestroyedAt = &now
+		}
+		if record.PrunedAt == nil {
+			record.PrunedAt = &now
+		}
+		record.Contents = ""
+		record.HMAC = ""
+		record.KPID = ""
+		return nil
+	})
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (b *BoltDBStorage) UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error {
+	return b.update(keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if record.KPID == newKPId {
+			return nil
+		}
+		if record.KPID != oldKPId {
+			return common.ErrVersionConflict
+		}
+		record.KPID = newKPId
+		return nil
+	})
+}
+
+// update applies fn to a stored version inside a single write transaction.
+func (b *BoltDBStorage) update(keyPath string, version int, fn func(record *kvRecord) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		kv, err := kvBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := kv.Bucket([]byte(keyPath))
+		if bucket == nil {
+			return common.ErrVersionNotFound
+		}
+
+		value := bucket.Get(versionKey(version))
+		if value == nil {
+			return common.ErrVersionNotFound
+		}
+
+		var record kvRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+
+		value, err = json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(versionKey(version), value)
+	})
+}
+
+// kvBucket returns the top-level bucket, which only exists once the storage is migrated.
+func kvBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	bucket := tx.Bucket(kvStoreBucket)
+	if bucket == nil {
+		return nil, fmt.Errorf("bucket %s does not exist", kvStoreBucket)
+	}
+	return bucket, nil
+}
+
+// versionKey encodes a version so that byte ordering matches numeric ordering.
+func versionKey(version int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(version))
+	return k
+}
+
+func (r kvRecord) versionInfo(version int) common.VersionInfo {
+	return common.VersionInfo{
+		Version: version,
+		Metadata: common.Metadata{
+			CreatedAt:   r.CreatedAt,
+			Encryptor:   r.Encryptor,
+			KeyProvider: r.KeyProvider,
+			CreatedBy:   r.CreatedBy,
+		},
+		DeletedAt:   r.DeletedAt,
+		DestroyedAt: r.DestroyedAt,
+		PrunedAt:    r.PrunedAt,
+	}
+}
@@ -0,0 +1,72 @@
+package boltdb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+func TestUnmigratedDatabase(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewBoltDBStorage(filepath.Join(t.TempDir(), "owlvault.db"))
+	if err != nil {
+		t.Fatalf("NewBoltDBStorage: %v", err)
+	}
+	record := common.Record{KeyPath: "app/db", Version: 1, Contents: "contents"}
+
+	// Every operation fails instead of panicking until the top-level bucket is created
+	calls := map[string]func() error{
+		"Ping":  func() error { return b.Ping(ctx) },
+		"Store": func() error { return b.Store(ctx, record) },
+		"BatchStore": func() error {
+			_, err := b.BatchStore(ctx, []common.Record{record})
+			return err
+		},
+		"StoreAtomic": func() error { return b.StoreAtomic(ctx, []common.Record{record}) },
+		"Retrieve": func() error {
+			_, _, _, err := b.Retrieve(ctx, "app/db", 1)
+			return err
+		},
+		"LatestVersion": func() error {
+			_, err := b.LatestVersion(ctx, "app/db")
+			return err
+		},
+		"BatchRetrieve": func() error {
+			_, err := b.BatchRetrieve(ctx, []common.VersionKey{{KeyPath: "app/db", Version: 1}})
+			return err
+		},
+		"BatchLatestVersion": func() error {
+			_, err := b.BatchLatestVersion(ctx, []string{"app/db"})
+			return err
+		},
+		"Versions": func() error {
+			_, err := b.Versions(ctx, "app/db")
+			return err
+		},
+		"Export": func() error {
+			_, err := b.Export(ctx, "app/db")
+			return err
+		},
+		"List": func() error {
+			_, _, err := b.List(ctx, "", 10, "")
+			return err
+		},
+		"Delete": func() error { return b.Delete(ctx, "app/db", 1) },
+	}
+	for name, call := range calls {
+		if err := call(); err == nil {
+			t.Errorf("%s: succeeded on an unmigrated database", name)
+		}
+	}
+
+	if err := b.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	for _, name := range []string{"Ping", "Store", "Retrieve", "List", "Delete"} {
+		if err := calls[name](); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
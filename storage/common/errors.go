@@ -0,0 +1,35 @@
+package common
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrVersionConflict is returned by Store when the version being written already exists for the key path.
+var ErrVersionConflict = errors.New("version already exists")
+
+// ErrVersionNotFound is returned when an operation targets a version that was never stored.
+var ErrVersionNotFound = errors.New("version not found")
+
+// ErrVersionDeleted is returned by Retrieve for a soft-deleted version.
+var ErrVersionDeleted = errors.New("version is deleted")
+
+// ErrVersionDestroyed is returned for a version whose contents have been permanently erased.
+var ErrVersionDestroyed = errors.New("version is destroyed")
+
+// ErrInvalidToken is returned by List for a continuation token it did not issue.
+var ErrInvalidToken = errors.New("invalid continuation token")
+
+// ErrAtomicBatchTooLarge is returned by StoreAtomic when the backend cannot commit that many records in one transaction.
+var ErrAtomicBatchTooLarge = errors.New("too many records for an atomic batch")
+
+// StateError maps the deletion timestamps of a stored version to the error Retrieve should report, if any.
+func StateError(deletedAt, destroyedAt *time.Time) error {
+	if destroyedAt != nil {
+		return ErrVersionDestroyed
+	}
+	if deletedAt != nil {
+		return ErrVersionDeleted
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Metadata is recorded alongside each stored version.
+type Metadata struct {
+	CreatedAt   time.Time
+	Encryptor   string
+	KeyProvider string
+	CreatedBy   string
+}
+
+// Record is a single stored version of a key path.
+type Record struct {
+	KeyPath  string
+	Version  int
+	Contents string
+	HMAC     string
+	KPId     string
+	Metadata Metadata
+}
+
+// VersionInfo describes a stored version without its contents.
+type VersionInfo struct {
+	Version     int
+	Metadata    Metadata
+	DeletedAt   *time.Time
+	DestroyedAt *time.Time
+	// PrunedAt is set when the version was destroyed by the retention policy rather than on request.
+	PrunedAt *time.Time
+}
+
+// VersionKey identifies a single stored version of a key path.
+type VersionKey struct {
+	KeyPath string
+	Version int
+}
+
+// RetrieveResult is the outcome of retrieving one version in a batch. A version that was
+// never stored yields empty values and no error, as with Retrieve.
+type RetrieveResult struct {
+	Contents string
+	HMAC     string
+	KPId     string
+	Err      error
+}
+
+// KeySummary describes a stored key path without any of its contents.
+type KeySummary struct {
+	KeyPath       string
+	LatestVersion int
+}
+
+// EncodeToken turns a backend-specific resume position into an opaque continuation token.
+func EncodeToken(position string) string {
+	if position == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+// DecodeToken reverses EncodeToken.
+func DecodeToken(token string) (string, error) {
+	position, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return string(position), nil
+}
+
+// EscapeLike escapes the SQL LIKE wildcards in s so that it only matches literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Page trims summaries fetched with one extra row to limit and derives the continuation
+// token from the last key path kept, for backends that list in key path order.
+func Page(summaries []KeySummary, limit int) ([]KeySummary, string) {
+	if len(summaries) <= limit {
+		return summaries, ""
+	}
+	summaries = summaries[:limit]
+	return summaries, EncodeToken(summaries[limit-1].KeyPath)
+}
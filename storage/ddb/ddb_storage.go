@@ -1,29 +1,139 @@
 package ddb
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+// Billing modes accepted by WithBillingMode.
+const (
+	BillingModeProvisioned   = dynamodb.BillingModeProvisioned
+	BillingModePayPerRequest = dynamodb.BillingModePayPerRequest
 )
 
+// batchGetLimit is the most keys a single BatchGetItem request may name.
+const batchGetLimit = 100
+
+// maxBatchAttempts bounds how often unprocessed keys of a batch request are retried.
+const maxBatchAttempts = 5
+
+// transactWriteLimit is the most items a single TransactWriteItems request may write.
+const transactWriteLimit = 100
+
+// batchConcurrency bounds the concurrent requests issued for operations DynamoDB cannot batch.
+const batchConcurrency = 16
+
 // DynamoDBStorage implements the Storage interface for DynamoDB.
 type DynamoDBStorage struct {
 	svc *dynamodb.DynamoDB
 
 	tablePrefix string
+	table       tableSettings
+}
+
+// tableSettings describes how Migrate creates the kv_store table and what it expects of an existing one.
+type tableSettings struct {
+	endpoint            string
+	billingMode         string
+	readCapacityUnits   int64
+	writeCapacityUnits  int64
+	pointInTimeRecovery bool
+	sseEnabled          bool
+	sseKMSKeyId         string
+	tags                map[string]string
+}
+
+// Option represents an option for configuring a new DynamoDBStorage.
+type Option func(*tableSettings)
+
+// WithEndpoint sends requests to a custom endpoint, such as DynamoDB Local, instead of the regional one.
+func WithEndpoint(endpoint string) Option {
+	return func(t *tableSettings) {
+		t.endpoint = endpoint
+	}
+}
+
+// WithBillingMode sets the billing mode of a newly created table. The capacity units only
+// apply to BillingModeProvisioned. The default is provisioned with 5 read and 5 write units.
+func WithBillingMode(mode string, readCapacityUnits, writeCapacityUnits int64) Option {
+	return func(t *tableSettings) {
+		t.billingMode = mode
+		t.readCapacityUnits = readCapacityUnits
+		t.writeCapacityUnits = writeCapacityUnits
+	}
+}
+
+// WithPointInTimeRecovery enables point-in-time recovery on the table.
+func WithPointInTimeRecovery(enabled bool) Option {
+	return func(t *tableSettings) {
+		t.pointInTimeRecovery = enabled
+	}
+}
+
+// WithSSE encrypts a newly created table with a KMS key, the AWS managed one when kmsKeyId is empty.
+// Without it DynamoDB uses an AWS owned key.
+func WithSSE(enabled bool, kmsKeyId string) Option {
+	return func(t *tableSettings) {
+		t.sseEnabled = enabled
+		t.sseKMSKeyId = kmsKeyId
+	}
+}
+
+// WithTags tags the table.
+func WithTags(tags map[string]string) Option {
+	return func(t *tableSettings) {
+		t.tags = tags
+	}
 }
 
 // NewDynamoDBStorage creates a new instance of DynamoDBStorage.
-func NewDynamoDBStorage(region string, tablePrefix string) (*DynamoDBStorage, error) {
+func NewDynamoDBStorage(region string, tablePrefix string, opts ...Option) (*DynamoDBStorage, error) {
+	table := tableSettings{
+		billingMode:        BillingModeProvisioned,
+		readCapacityUnits:  5,
+		writeCapacityUnits: 5,
+	}
+	for _, opt := range opts {
+		opt(&table)
+	}
+
+	switch table.billingMode {
+	case BillingModeProvisioned:
+		if table.readCapacityUnits < 1 || table.writeCapacityUnits < 1 {
+			return nil, fmt.Errorf("provisioned billing mode requires positive read and write capacity units")
+		}
+	case BillingModePayPerRequest:
+	default:
+		return nil, fmt.Errorf("unsupported billing mode %q, expected %s or %s", table.billingMode, BillingModeProvisioned, BillingModePayPerRequest)
+	}
+	if table.sseKMSKeyId != "" && !table.sseEnabled {
+		return nil, fmt.Errorf("an SSE KMS key requires SSE to be enabled")
+	}
+
 	// Initialize DynamoDB client
-	sess, err := session.NewSession(&aws.Config{
+	awsConfig := &aws.Config{
 		Region: aws.String(region),
-	})
+	}
+	if table.endpoint != "" {
+		awsConfig.Endpoint = aws.String(table.endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -32,41 +142,97 @@ func NewDynamoDBStorage(region string, tablePrefix string) (*DynamoDBStorage, er
 	return &DynamoDBStorage{
 		svc:         svc,
 		tablePrefix: tablePrefix,
+		table:       table,
 	}, nil
 }
 
-// Store stores the key-value pair with the specified version.
-func (d *DynamoDBStorage) Store(keyPath string, contents string, hmac string, kpId string, version int) error {
+// Ping checks that the kv_store table exists and is active.
+func (d *DynamoDBStorage) Ping(ctx context.Context) error {
+	tableName := d.tablePrefix + "kv_store"
+
+	table, err := d.describeTable(ctx, tableName)
+	if err != nil {
+		return fmt.Errorf("failed to describe table %s: %v", tableName, err)
+	}
+	if table == nil {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	if status := aws.StringValue(table.TableStatus); status != dynamodb.TableStatusActive && status != dynamodb.TableStatusUpdating {
+		return fmt.Errorf("table %s is %s", tableName, status)
+	}
+	return nil
+}
+
+// Store stores the record with its metadata.
+func (d *DynamoDBStorage) Store(ctx context.Context, record common.Record) error {
 	kvStoreTableName := d.tablePrefix + "kv_store" // Change to your DynamoDB table name
 
-	// Marshal key-value pair to DynamoDB attribute values
-	av, err := dynamodbattribute.MarshalMap(map[string]interface{}{
-		"key_path": keyPath,
-		"version":  fmt.Sprintf("%019d", version),
-		"contents": contents,
-		"hmac":     hmac,
-		"kp_id":    kpId,
-	})
+	av, err := recordItem(record)
 	if err != nil {
 		return err
 	}
 
-	// Create input for PutItem operation
+	// Create input for PutItem operation, refusing to overwrite an existing version
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(kvStoreTableName), // Change to your DynamoDB table name
-		Item:      av,
+		TableName:           aws.String(kvStoreTableName), // Change to your DynamoDB table name
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(key_path)"),
 	}
 
 	// Execute PutItem operation
-	_, err = d.svc.PutItem(input)
+	_, err = d.svc.PutItemWithContext(ctx, input)
 	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return common.ErrVersionConflict
+		}
 		return fmt.Errorf("failed to store item: %v", err)
 	}
 	return nil
 }
 
+// StoreAtomic stores the records with a single TransactWriteItems call, which DynamoDB
+// limits to 100 items.
+func (d *DynamoDBStorage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if len(records) > transactWriteLimit {
+		return fmt.Errorf("%w: DynamoDB commits at most %d records at once", common.ErrAtomicBatchTooLarge, transactWriteLimit)
+	}
+
+	items := make([]*dynamodb.TransactWriteItem, len(records))
+	for i, record := range records {
+		av, err := recordItem(record)
+		if err != nil {
+			return err
+		}
+		items[i] = &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(d.tablePrefix + "kv_store"),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(key_path)"),
+			},
+		}
+	}
+
+	_, err := d.svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+
+	var canceled *dynamodb.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+				return common.ErrVersionConflict
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store items: %v", err)
+	}
+	return nil
+}
+
 // Retrieve retrieves the value for the specified key and version.
-func (d *DynamoDBStorage) Retrieve(keyPath string, version int) (string, string, string, error) {
+func (d *DynamoDBStorage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
 	kvStoreTableName := d.tablePrefix + "kv_store"
 
 	// Create input for GetItem operation
@@ -83,26 +249,31 @@ func (d *DynamoDBStorage) Retrieve(keyPath string, version int) (string, string,
 	}
 
 	// Execute GetItem operation
-	result, err := d.svc.GetItem(input)
+	result, err := d.svc.GetItemWithContext(ctx, input)
 	if err != nil {
 		return "", "", "", fmt.Errorf("failed to retrieve item: %v", err)
 	}
 
 	// Unmarshal retrieved item
 	item := struct {
-		Contents string `json:"contents"`
-		HMAC     string `json:"hmac"`
-		KPID     string `json:"kp_id"`
+		Contents    string `json:"contents"`
+		HMAC        string `json:"hmac"`
+		KPID        string `json:"kp_id"`
+		DeletedAt   string `json:"deleted_at"`
+		DestroyedAt string `json:"destroyed_at"`
 	}{}
 	if err := dynamodbattribute.UnmarshalMap(result.Item, &item); err != nil {
 		return "", "", "", fmt.Errorf("failed to unmarshal item: %v", err)
 	}
+	if err := common.StateError(parseTime(item.DeletedAt), parseTime(item.DestroyedAt)); err != nil {
+		return "", "", "", err
+	}
 
 	return item.Contents, item.HMAC, item.KPID, nil
 }
 
 // LatestVersion is not applicable for DynamoDB storage
-func (d *DynamoDBStorage) LatestVersion(key string) (int, error) {
+func (d *DynamoDBStorage) LatestVersion(ctx context.Context, key string) (int, error) {
 	kvStoreTableName := d.tablePrefix + "kv_store"
 
 	// Define input for query
@@ -119,10 +290,11 @@ func (d *DynamoDBStorage) LatestVersion(key string) (int, error) {
 		},
 		ScanIndexForward: aws.Bool(false), // Sort results in descending order
 		Limit:            aws.Int64(1),    // Limit to 1 item
+		ConsistentRead:   aws.Bool(true),
 	}
 
 	// Execute query
-	result, err := d.svc.Query(input)
+	result, err := d.svc.QueryWithContext(ctx, input)
 	if err != nil {
 		return 0, fmt.Errorf("failed to query DynamoDB: %v", err)
 	}
@@ -147,44 +319,480 @@ func (d *DynamoDBStorage) LatestVersion(key string) (int, error) {
 
 }
 
-// Migrate is not applicable for DynamoDB storage
-func (d *DynamoDBStorage) Migrate() error {
+// BatchStore stores the records with concurrent conditional PutItem calls. BatchWriteItem
+// cannot be used because it does not support condition expressions, and without them a
+// concurrent writer's version would be silently overwritten.
+func (d *DynamoDBStorage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	errs := make([]error, len(records))
+	parallel(len(records), func(i int) {
+		errs[i] = d.Store(ctx, records[i])
+	})
+	return errs, nil
+}
+
+// BatchRetrieve fetches the versions with BatchGetItem, 100 keys per request.
+func (d *DynamoDBStorage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	tableName := d.tablePrefix + "kv_store"
+
+	// BatchGetItem rejects requests that name the same key twice
+	var unique []common.VersionKey
+	seen := make(map[common.VersionKey]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	found := make(map[common.VersionKey]common.RetrieveResult, len(unique))
+	for start := 0; start < len(unique); start += batchGetLimit {
+		chunk := unique[start:min(start+batchGetLimit, len(unique))]
+
+		requestKeys := make([]map[string]*dynamodb.AttributeValue, len(chunk))
+		for i, key := range chunk {
+			requestKeys[i] = itemKey(key.KeyPath, key.Version)
+		}
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			tableName: {Keys: requestKeys},
+		}
+
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt > 0 {
+				// Unprocessed keys are returned when the table is throttled, so back off before retrying
+				if attempt > maxBatchAttempts {
+					return nil, fmt.Errorf("failed to retrieve items: unprocessed keys remain after %d attempts", maxBatchAttempts)
+				}
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(time.Duration(1<<attempt) * 25 * time.Millisecond):
+				}
+			}
+
+			output, err := d.svc.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve items: %v", err)
+			}
+
+			for _, item := range output.Responses[tableName] {
+				attrs := struct {
+					KeyPath     string `json:"key_path"`
+					Version     string `json:"version"`
+					Contents    string `json:"contents"`
+					HMAC        string `json:"hmac"`
+					KPID        string `json:"kp_id"`
+					DeletedAt   string `json:"deleted_at"`
+					DestroyedAt string `json:"destroyed_at"`
+				}{}
+				if err := dynamodbattribute.UnmarshalMap(item, &attrs); err != nil {
+					return nil, fmt.Errorf("failed to unmarshal item: %v", err)
+				}
+				version, err := strconv.Atoi(attrs.Version)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse version attribute: %v", err)
+				}
+
+				result := common.RetrieveResult{Contents: attrs.Contents, HMAC: attrs.HMAC, KPId: attrs.KPID}
+				if err := common.StateError(parseTime(attrs.DeletedAt), parseTime(attrs.DestroyedAt)); err != nil {
+					result = common.RetrieveResult{Err: err}
+				}
+				found[common.VersionKey{KeyPath: attrs.KeyPath, Version: version}] = result
+			}
+
+			requestItems = output.UnprocessedKeys
+		}
+	}
+
+	results := make([]common.RetrieveResult, len(keys))
+	for i, key := range keys {
+		results[i] = found[key]
+	}
+	return results, nil
+}
+
+// BatchLatestVersion queries the latest version of each key path concurrently, since
+// DynamoDB has no batch form of Query.
+func (d *DynamoDBStorage) BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	versions := make([]int, len(keyPaths))
+	errs := make([]error, len(keyPaths))
+	parallel(len(keyPaths), func(i int) {
+		versions[i], errs[i] = d.LatestVersion(ctx, keyPaths[i])
+	})
+
+	latest := make(map[string]int, len(keyPaths))
+	for i, keyPath := range keyPaths {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if versions[i] > 0 {
+			latest[keyPath] = versions[i]
+		}
+	}
+	return latest, nil
+}
+
+// Versions describes every stored version of the key path in ascending order.
+func (d *DynamoDBStorage) Versions(ctx context.Context, keyPath string) ([]common.VersionInfo, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.tablePrefix + "kv_store"),
+		KeyConditionExpression: aws.String("#key_path = :key_path"),
+		ProjectionExpression:   aws.String("#version, created_at, encryptor, key_provider, created_by, deleted_at, destroyed_at, pruned_at"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key_path": aws.String("key_path"),
+			"#version":  aws.String("version"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key_path": {
+				S: aws.String(keyPath),
+			},
+		},
+	}
+
+	var versions []common.VersionInfo
+	for {
+		result, err := d.svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query DynamoDB: %v", err)
+		}
+
+		for _, item := range result.Items {
+			info, err := versionInfo(item)
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, info)
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return versions, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Export returns every stored version of the key path in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (d *DynamoDBStorage) Export(ctx context.Context, keyPath string) ([]common.Record, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.tablePrefix + "kv_store"),
+		KeyConditionExpression: aws.String("#key_path = :key_path"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key_path": aws.String("key_path"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key_path": {
+				S: aws.String(keyPath),
+			},
+		},
+	}
+
+	var records []common.Record
+	for {
+		result, err := d.svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query DynamoDB: %v", err)
+		}
+
+		for _, item := range result.Items {
+			info, err := versionInfo(item)
+			if err != nil {
+				return nil, err
+			}
+
+			contents := struct {
+				Contents string `json:"contents"`
+				HMAC     string `json:"hmac"`
+				KPID     string `json:"kp_id"`
+			}{}
+			if err := dynamodbattribute.UnmarshalMap(item, &contents); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal item: %v", err)
+			}
+
+			records = append(records, common.Record{
+				KeyPath:  keyPath,
+				Version:  info.Version,
+				Contents: contents.Contents,
+				HMAC:     contents.HMAC,
+				KPId:     contents.KPID,
+				Metadata: info.Metadata,
+			})
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return records, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// List returns key paths starting with prefix, with their latest versions. DynamoDB has no
+// ordered index over partition keys, so this scans the table and key paths come back in
+// DynamoDB's internal order. Versions of one key path are stored together, so a page always
+// ends after the last version of its final key path and the token resumes from there.
+func (d *DynamoDBStorage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(d.tablePrefix + "kv_store"),
+		ProjectionExpression: aws.String("#key_path, #version"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key_path": aws.String("key_path"),
+			"#version":  aws.String("version"),
+		},
+	}
+	if prefix != "" {
+		input.FilterExpression = aws.String("begins_with(#key_path, :prefix)")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(prefix)},
+		}
+	}
+	if token != "" {
+		position, err := common.DecodeToken(token)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := json.Unmarshal([]byte(position), &input.ExclusiveStartKey); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", common.ErrInvalidToken, err)
+		}
+	}
+
+	var summaries []common.KeySummary
+	var current *common.KeySummary
+	var currentLastKey map[string]*dynamodb.AttributeValue
+
+	for {
+		result, err := d.svc.ScanWithContext(ctx, input)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan DynamoDB: %v", err)
+		}
+
+		for _, item := range result.Items {
+			keyPath := aws.StringValue(item["key_path"].S)
+			version, err := strconv.Atoi(aws.StringValue(item["version"].S))
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to parse version attribute: %v", err)
+			}
+
+			if current != nil && current.KeyPath == keyPath {
+				current.LatestVersion = version
+				currentLastKey = item
+				continue
+			}
+
+			if current != nil {
+				summaries = append(summaries, *current)
+				if len(summaries) == limit {
+					next, err := json.Marshal(currentLastKey)
+					if err != nil {
+						return nil, "", err
+					}
+					return summaries, common.EncodeToken(string(next)), nil
+				}
+			}
+			current = &common.KeySummary{KeyPath: keyPath, LatestVersion: version}
+			currentLastKey = item
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	if current != nil {
+		summaries = append(summaries, *current)
+	}
+	return summaries, "", nil
+}
+
+// Delete soft-deletes the specified version.
+func (d *DynamoDBStorage) Delete(ctx context.Context, keyPath string, version int) error {
+	_, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.tablePrefix + "kv_store"),
+		Key:                 itemKey(keyPath, version),
+		UpdateExpression:    aws.String("SET deleted_at = if_not_exists(deleted_at, :now)"),
+		ConditionExpression: aws.String("attribute_exists(key_path) AND attribute_not_exists(destroyed_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {S: aws.String(formatTime(time.Now()))},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return d.explainConditionFailure(ctx, keyPath, version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete item: %v", err)
+	}
+	return nil
+}
+
+// Undelete recovers a soft-deleted version.
+func (d *DynamoDBStorage) Undelete(ctx context.Context, keyPath string, version int) error {
+	_, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.tablePrefix + "kv_store"),
+		Key:                 itemKey(keyPath, version),
+		UpdateExpression:    aws.String("REMOVE deleted_at"),
+		ConditionExpression: aws.String("attribute_exists(key_path) AND attribute_not_exists(destroyed_at)"),
+	})
+	if isConditionalCheckFailed(err) {
+		return d.explainConditionFailure(ctx, keyPath, version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to undelete item: %v", err)
+	}
+	return nil
+}
+
+// Destroy permanently erases the contents of the specified version.
+func (d *DynamoDBStorage) Destroy(ctx context.Context, keyPath string, version int) error {
+	return d.erase(ctx, keyPath, version, "destroyed_at = if_not_exists(destroyed_at, :now)")
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (d *DynamoDBStorage) Prune(ctx context.Context, keyPath string, version int) error {
+	return d.erase(ctx, keyPath, version, "destroyed_at = if_not_exists(destroyed_at, :now), pruned_at = if_not_exists(pruned_at, :now)")
+}
+
+// erase clears the contents of an existing version and applies the given timestamp assignments.
+func (d *DynamoDBStorage) erase(ctx context.Context, keyPath string, version int, stamps string) error {
+	_, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.tablePrefix + "kv_store"),
+		Key:                 itemKey(keyPath, version),
+		UpdateExpression:    aws.String("SET contents = :empty, hmac = :empty, kp_id = :empty, " + stamps),
+		ConditionExpression: aws.String("attribute_exists(key_path)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":empty": {S: aws.String("")},
+			":now":   {S: aws.String(formatTime(time.Now()))},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return common.ErrVersionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to destroy item: %v", err)
+	}
+	return nil
+}
+
+// explainConditionFailure reports why a conditional deletion state update was rejected.
+func (d *DynamoDBStorage) explainConditionFailure(ctx context.Context, keyPath string, version int) error {
+	result, err := d.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(d.tablePrefix + "kv_store"),
+		Key:                  itemKey(keyPath, version),
+		ProjectionExpression: aws.String("key_path"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve item: %v", err)
+	}
+	if len(result.Item) == 0 {
+		return common.ErrVersionNotFound
+	}
+	return common.ErrVersionDestroyed
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (d *DynamoDBStorage) UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error {
+	_, err := d.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.tablePrefix + "kv_store"),
+		Key:                 itemKey(keyPath, version),
+		UpdateExpression:    aws.String("SET kp_id = :new"),
+		ConditionExpression: aws.String("attribute_exists(key_path) AND attribute_not_exists(destroyed_at) AND kp_id = :old"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":old": {S: aws.String(oldKPId)},
+			":new": {S: aws.String(newKPId)},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return d.explainKPIdConflict(ctx, keyPath, version, newKPId)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update item: %v", err)
+	}
+	return nil
+}
+
+// explainKPIdConflict reports why a conditional key provider blob update was rejected, which
+// is not an error if the version already holds the new blob.
+func (d *DynamoDBStorage) explainKPIdConflict(ctx context.Context, keyPath string, version int, newKPId string) error {
+	result, err := d.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(d.tablePrefix + "kv_store"),
+		Key:                  itemKey(keyPath, version),
+		ProjectionExpression: aws.String("key_path, kp_id, destroyed_at"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve item: %v", err)
+	}
+	if len(result.Item) == 0 {
+		return common.ErrVersionNotFound
+	}
+	if _, ok := result.Item["destroyed_at"]; ok {
+		return common.ErrVersionDestroyed
+	}
+	if kpId := result.Item["kp_id"]; kpId == nil || aws.StringValue(kpId.S) != newKPId {
+		return common.ErrVersionConflict
+	}
+	return nil
+}
+
+// Migrate creates the kv_store table with the configured settings if it does not exist,
+// and otherwise checks that the existing table matches them. Point-in-time recovery and
+// tags are applied to existing tables as well.
+func (d *DynamoDBStorage) Migrate(ctx context.Context) error {
 	// Check if the table exists
 	kvStoreTableName := d.tablePrefix + "kv_store" // Change to your DynamoDB table name
-	exists, err := d.checkTableExists(kvStoreTableName)
+	table, err := d.describeTable(ctx, kvStoreTableName)
 	if err != nil {
 		return fmt.Errorf("failed to check if table exists: %v", err)
 	}
-	if !exists {
+	if table == nil {
 		// Table does not exist, create it
-		if err := d.createKVStoreTable(kvStoreTableName); err != nil {
+		if table, err = d.createKVStoreTable(ctx, kvStoreTableName); err != nil {
 			return fmt.Errorf("failed to create table: %v", err)
 		}
 		fmt.Printf("Table '%s' created successfully\n", kvStoreTableName)
 	} else {
+		if err := d.validateTable(table); err != nil {
+			return fmt.Errorf("table '%s' does not match the configuration: %v", kvStoreTableName, err)
+		}
 		fmt.Printf("Table '%s' already exists\n", kvStoreTableName)
 	}
 
+	if d.table.pointInTimeRecovery {
+		if err := d.enablePointInTimeRecovery(ctx, kvStoreTableName); err != nil {
+			return fmt.Errorf("failed to enable point-in-time recovery: %v", err)
+		}
+	}
+
+	if len(d.table.tags) > 0 {
+		_, err := d.svc.TagResourceWithContext(ctx, &dynamodb.TagResourceInput{
+			ResourceArn: table.TableArn,
+			Tags:        d.tagList(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag table: %v", err)
+		}
+	}
+
 	return nil
 }
 
-// checkTableExists checks if the table exists in DynamoDB.
-func (d *DynamoDBStorage) checkTableExists(tableName string) (bool, error) {
+// describeTable returns the description of the table, or nil if it does not exist.
+func (d *DynamoDBStorage) describeTable(ctx context.Context, tableName string) (*dynamodb.TableDescription, error) {
 	// Describe table to check if it exists
-	_, err := d.svc.DescribeTable(&dynamodb.DescribeTableInput{
+	output, err := d.svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
 		TableName: aws.String(tableName),
 	})
 	if err != nil {
 		var resourceNotFoundException *dynamodb.ResourceNotFoundException
 		if errors.As(err, &resourceNotFoundException) {
-			return false, nil // Table does not exist
+			return nil, nil // Table does not exist
 		}
-		return false, err // Other error occurred
+		return nil, err // Other error occurred
 	}
-	return true, nil // Table exists
+	return output.Table, nil // Table exists
 }
 
-func (d *DynamoDBStorage) createKVStoreTable(tableName string) error {
+func (d *DynamoDBStorage) createKVStoreTable(ctx context.Context, tableName string) (*dynamodb.TableDescription, error) {
 	// Define table schema
 	input := &dynamodb.CreateTableInput{
 		AttributeDefinitions: []*dynamodb.AttributeDefinition{
@@ -207,14 +815,213 @@ func (d *DynamoDBStorage) createKVStoreTable(tableName string) error {
 				KeyType:       aws.String("RANGE"),
 			},
 		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(5), // Adjust as needed
-			WriteCapacityUnits: aws.Int64(5), // Adjust as needed
-		},
-		TableName: aws.String(tableName),
+		BillingMode: aws.String(d.table.billingMode),
+		TableName:   aws.String(tableName),
+	}
+	if d.table.billingMode == BillingModeProvisioned {
+		input.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(d.table.readCapacityUnits),
+			WriteCapacityUnits: aws.Int64(d.table.writeCapacityUnits),
+		}
+	}
+	if d.table.sseEnabled {
+		input.SSESpecification = &dynamodb.SSESpecification{
+			Enabled: aws.Bool(true),
+			SSEType: aws.String(dynamodb.SSETypeKms),
+		}
+		if d.table.sseKMSKeyId != "" {
+			input.SSESpecification.KMSMasterKeyId = aws.String(d.table.sseKMSKeyId)
+		}
 	}
 
 	// Create table
-	_, err := d.svc.CreateTable(input)
+	if _, err := d.svc.CreateTableWithContext(ctx, input); err != nil {
+		return nil, err
+	}
+
+	// Backups and tags can only be configured once the table is active
+	describeInput := &dynamodb.DescribeTableInput{TableName: aws.String(tableName)}
+	if err := d.svc.WaitUntilTableExistsWithContext(ctx, describeInput); err != nil {
+		return nil, err
+	}
+	return d.describeTable(ctx, tableName)
+}
+
+// validateTable checks that an existing table has the expected key schema and the configured
+// billing mode and encryption. Capacity units are not compared since autoscaling may change them.
+func (d *DynamoDBStorage) validateTable(table *dynamodb.TableDescription) error {
+	keyTypes := map[string]string{}
+	for _, element := range table.KeySchema {
+		keyTypes[aws.StringValue(element.AttributeName)] = aws.StringValue(element.KeyType)
+	}
+	if len(keyTypes) != 2 || keyTypes["key_path"] != dynamodb.KeyTypeHash || keyTypes["version"] != dynamodb.KeyTypeRange {
+		return fmt.Errorf("expected key_path as hash key and version as range key")
+	}
+
+	// Tables created before billing modes existed report no summary and are provisioned
+	billingMode := BillingModeProvisioned
+	if table.BillingModeSummary != nil {
+		billingMode = aws.StringValue(table.BillingModeSummary.BillingMode)
+	}
+	if billingMode != d.table.billingMode {
+		return fmt.Errorf("billing mode is %s, configured %s", billingMode, d.table.billingMode)
+	}
+
+	if d.table.sseEnabled {
+		sse := table.SSEDescription
+		if sse == nil || aws.StringValue(sse.SSEType) != dynamodb.SSETypeKms {
+			return fmt.Errorf("KMS server-side encryption is configured but not enabled on the table")
+		}
+		keyArn := aws.StringValue(sse.KMSMasterKeyArn)
+		keyId := d.table.sseKMSKeyId
+		// Aliases cannot be compared with the key ARN the table reports
+		if keyId != "" && !strings.HasPrefix(keyId, "alias/") && keyArn != keyId && !strings.HasSuffix(keyArn, "/"+keyId) {
+			return fmt.Errorf("table is encrypted with %s, configured %s", keyArn, keyId)
+		}
+	}
+
+	return nil
+}
+
+// enablePointInTimeRecovery turns on continuous backups unless they are already on.
+func (d *DynamoDBStorage) enablePointInTimeRecovery(ctx context.Context, tableName string) error {
+	backups, err := d.svc.DescribeContinuousBackupsWithContext(ctx, &dynamodb.DescribeContinuousBackupsInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return err
+	}
+	if description := backups.ContinuousBackupsDescription; description != nil && description.PointInTimeRecoveryDescription != nil &&
+		aws.StringValue(description.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus) == dynamodb.PointInTimeRecoveryStatusEnabled {
+		return nil
+	}
+
+	_, err = d.svc.UpdateContinuousBackupsWithContext(ctx, &dynamodb.UpdateContinuousBackupsInput{
+		TableName: aws.String(tableName),
+		PointInTimeRecoverySpecification: &dynamodb.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(true),
+		},
+	})
 	return err
 }
+
+// tagList converts the configured tags to DynamoDB tags in a stable order.
+func (d *DynamoDBStorage) tagList() []*dynamodb.Tag {
+	keys := make([]string, 0, len(d.table.tags))
+	for key := range d.table.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := make([]*dynamodb.Tag, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, &dynamodb.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(d.table.tags[key]),
+		})
+	}
+	return tags
+}
+
+// itemKey builds the primary key of a stored version.
+func itemKey(keyPath string, version int) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"key_path": {
+			S: aws.String(keyPath),
+		},
+		"version": {
+			S: aws.String(fmt.Sprintf("%019d", version)),
+		},
+	}
+}
+
+// recordItem marshals a record into the attribute values of its item.
+func recordItem(record common.Record) (map[string]*dynamodb.AttributeValue, error) {
+	return dynamodbattribute.MarshalMap(map[string]interface{}{
+		"key_path":     record.KeyPath,
+		"version":      fmt.Sprintf("%019d", record.Version),
+		"contents":     record.Contents,
+		"hmac":         record.HMAC,
+		"kp_id":        record.KPId,
+		"created_at":   formatTime(record.Metadata.CreatedAt),
+		"encryptor":    record.Metadata.Encryptor,
+		"key_provider": record.Metadata.KeyProvider,
+		"created_by":   record.Metadata.CreatedBy,
+	})
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime parses a timestamp attribute. A missing value is unset; a malformed one
+// still counts as set so that deletion state is never silently dropped.
+func parseTime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return &time.Time{}
+	}
+	return &t
+}
+
+// versionInfo unmarshals the metadata and deletion state of a stored item.
+func versionInfo(item map[string]*dynamodb.AttributeValue) (common.VersionInfo, error) {
+	attrs := struct {
+		Version     string `json:"version"`
+		CreatedAt   string `json:"created_at"`
+		Encryptor   string `json:"encryptor"`
+		KeyProvider string `json:"key_provider"`
+		CreatedBy   string `json:"created_by"`
+		DeletedAt   string `json:"deleted_at"`
+		DestroyedAt string `json:"destroyed_at"`
+		PrunedAt    string `json:"pruned_at"`
+	}{}
+	if err := dynamodbattribute.UnmarshalMap(item, &attrs); err != nil {
+		return common.VersionInfo{}, fmt.Errorf("failed to unmarshal item: %v", err)
+	}
+
+	version, err := strconv.Atoi(attrs.Version)
+	if err != nil {
+		return common.VersionInfo{}, fmt.Errorf("failed to parse version attribute: %v", err)
+	}
+
+	info := common.VersionInfo{
+		Version: version,
+		Metadata: common.Metadata{
+			Encryptor:   attrs.Encryptor,
+			KeyProvider: attrs.KeyProvider,
+			CreatedBy:   attrs.CreatedBy,
+		},
+		DeletedAt:   parseTime(attrs.DeletedAt),
+		DestroyedAt: parseTime(attrs.DestroyedAt),
+		PrunedAt:    parseTime(attrs.PrunedAt),
+	}
+	if createdAt := parseTime(attrs.CreatedAt); createdAt != nil {
+		info.Metadata.CreatedAt = *createdAt
+	}
+	return info, nil
+}
+
+// parallel calls fn for every index below n, running at most batchConcurrency calls at once.
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchConcurrency)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
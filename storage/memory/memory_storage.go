@@ -0,0 +1,336 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+// MemoryStorage implements the Storage interface in process memory.
+// Nothing is persisted; it is meant for tests and ephemeral environments.
+type MemoryStorage struct {
+	sync.RWMutex
+
+	versions map[string]map[int]entry
+}
+
+// entry is a single stored version of a key path.
+type entry struct {
+	contents string
+	hmac     string
+	kpId     string
+	metadata common.Metadata
+
+	deletedAt   *time.Time
+	destroyedAt *time.Time
+	prunedAt    *time.Time
+}
+
+// NewMemoryStorage creates a new, empty instance of MemoryStorage.
+func NewMemoryStorage() (*MemoryStorage, error) {
+	return &MemoryStorage{
+		versions: make(map[string]map[int]entry),
+	}, nil
+}
+
+// Migrate is a no-op for MemoryStorage.
+func (m *MemoryStorage) Migrate(ctx context.Context) error {
+	return nil
+}
+
+// Ping always succeeds for MemoryStorage.
+func (m *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Store stores the record with its metadata.
+func (m *MemoryStorage) Store(ctx context.Context, record common.Record) error {
+	m.Lock()
+	defer m.Unlock()
+
+	versions, ok := m.versions[record.KeyPath]
+	if !ok {
+		versions = make(map[int]entry)
+		m.versions[record.KeyPath] = versions
+	}
+
+	if _, exists := versions[record.Version]; exists {
+		return common.ErrVersionConflict
+	}
+
+	versions[record.Version] = entry{
+		contents: record.Contents,
+		hmac:     record.HMAC,
+		kpId:     record.KPId,
+		metadata: record.Metadata,
+	}
+	return nil
+}
+
+// Retrieve retrieves the value for the specified key and version.
+func (m *MemoryStorage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	r := m.versions[keyPath][version]
+	if err := common.StateError(r.deletedAt, r.destroyedAt); err != nil {
+		return "", "", "", err
+	}
+	return r.contents, r.hmac, r.kpId, nil
+}
+
+// LatestVersion returns the latest version of the value for the specified key.
+func (m *MemoryStorage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return latestOf(m.versions[keyPath]), nil
+}
+
+// BatchStore stores each record as Store would. There are no round trips to save in memory.
+func (m *MemoryStorage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	errs := make([]error, len(records))
+	for i, record := range records {
+		errs[i] = m.Store(ctx, record)
+	}
+	return errs, nil
+}
+
+// StoreAtomic stores all of the records or, if any version already exists, none of them.
+func (m *MemoryStorage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	m.Lock()
+	defer m.Unlock()
+
+	seen := make(map[common.VersionKey]bool, len(records))
+	for _, record := range records {
+		key := common.VersionKey{KeyPath: record.KeyPath, Version: record.Version}
+		if _, exists := m.versions[record.KeyPath][record.Version]; exists || seen[key] {
+			return common.ErrVersionConflict
+		}
+		seen[key] = true
+	}
+
+	for _, record := range records {
+		versions, ok := m.versions[record.KeyPath]
+		if !ok {
+			versions = make(map[int]entry)
+			m.versions[record.KeyPath] = versions
+		}
+		versions[record.Version] = entry{
+			contents: record.Contents,
+			hmac:     record.HMAC,
+			kpId:     record.KPId,
+			metadata: record.Metadata,
+		}
+	}
+	return nil
+}
+
+// BatchRetrieve retrieves each version as Retrieve would.
+func (m *MemoryStorage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	results := make([]common.RetrieveResult, len(keys))
+	for i, key := range keys {
+		r := &results[i]
+		r.Contents, r.HMAC, r.KPId, r.Err = m.Retrieve(ctx, key.KeyPath, key.Version)
+	}
+	return results, nil
+}
+
+// BatchLatestVersion returns the latest version of each key path that has one.
+func (m *MemoryStorage) BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	latest := make(map[string]int, len(keyPaths))
+	for _, keyPath := range keyPaths {
+		if version := latestOf(m.versions[keyPath]); version > 0 {
+			latest[keyPath] = version
+		}
+	}
+	return latest, nil
+}
+
+// Versions describes every stored version of the key path in ascending order.
+func (m *MemoryStorage) Versions(ctx context.Context, keyPath string) ([]common.VersionInfo, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var versions []common.VersionInfo
+	for version, r := range m.versions[keyPath] {
+		versions = append(versions, common.VersionInfo{
+			Version:     version,
+			Metadata:    r.metadata,
+			DeletedAt:   r.deletedAt,
+			DestroyedAt: r.destroyedAt,
+			PrunedAt:    r.prunedAt,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
+
+// Export returns every stored version of the key path in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (m *MemoryStorage) Export(ctx context.Context, keyPath string) ([]common.Record, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var records []common.Record
+	for version, r := range m.versions[keyPath] {
+		records = append(records, common.Record{
+			KeyPath:  keyPath,
+			Version:  version,
+			Contents: r.contents,
+			HMAC:     r.hmac,
+			KPId:     r.kpId,
+			Metadata: r.metadata,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Version < records[j].Version
+	})
+
+	return records, nil
+}
+
+// List returns key paths starting with prefix in key path order, with their latest versions.
+func (m *MemoryStorage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	after, err := common.DecodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	var keyPaths []string
+	for keyPath := range m.versions {
+		if strings.HasPrefix(keyPath, prefix) && keyPath > after {
+			keyPaths = append(keyPaths, keyPath)
+		}
+	}
+	sort.Strings(keyPaths)
+
+	var summaries []common.KeySummary
+	for _, keyPath := range keyPaths {
+		if len(summaries) > limit {
+			break
+		}
+
+		summaries = append(summaries, common.KeySummary{
+			KeyPath:       keyPath,
+			LatestVersion: latestOf(m.versions[keyPath]),
+		})
+	}
+
+	summaries, next := common.Page(summaries, limit)
+	return summaries, next, nil
+}
+
+// Delete soft-deletes the specified version.
+func (m *MemoryStorage) Delete(ctx context.Context, keyPath string, version int) error {
+	return m.update(keyPath, version, func(r *entry) error {
+		if r.destroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if r.deletedAt == nil {
+			now := time.Now().UTC()
+			r.deletedAt = &now
+		}
+		return nil
+	})
+}
+
+// Undelete recovers a soft-deleted version.
+func (m *MemoryStorage) Undelete(ctx context.Context, keyPath string, version int) error {
+	return m.update(keyPath, version, func(r *entry) error {
+		if r.destroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		r.deletedAt = nil
+		return nil
+	})
+}
+
+// Destroy permanently erases the contents of the specified version.
+func (m *MemoryStorage) Destroy(ctx context.Context, keyPath string, version int) error {
+	return m.update(keyPath, version, func(r *entry) error {
+		if r.destroyedAt == nil {
+			now := time.Now().UTC()
+			r.destroyedAt = &now
+		}
+		r.contents = ""
+		r.hmac = ""
+		r.kpId = ""
+		return nil
+	})
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (m *MemoryStorage) Prune(ctx context.Context, keyPath string, version int) error {
+	return m.update(keyPath, version, func(r *entry) error {
+		now := time.Now().UTC()
+		if r.destroyedAt == nil {
+			r.destroyedAt = &now
+		}
+		if r.prunedAt == nil {
+			r.prunedAt = &now
+		}
+		r.contents = ""
+		r.hmac = ""
+		r.kpId = ""
+		return nil
+	})
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (m *MemoryStorage) UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error {
+	return m.update(keyPath, version, func(r *entry) error {
+		if r.destroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if r.kpId == newKPId {
+			return nil
+		}
+		if r.kpId != oldKPId {
+			return common.ErrVersionConflict
+		}
+		r.kpId = newKPId
+		return nil
+	})
+}
+
+// update applies fn to a stored version while holding the write lock.
+func (m *MemoryStorage) update(keyPath string, version int, fn func(r *entry) error) error {
+	m.Lock()
+	defer m.Unlock()
+
+	r, ok := m.versions[keyPath][version]
+	if !ok {
+		return common.ErrVersionNotFound
+	}
+	if err := fn(&r); err != nil {
+		return err
+	}
+	m.versions[keyPath][version] = r
+	return nil
+}
+
+// latestOf returns the highest version in versions, or 0 when there is none.
+func latestOf(versions map[int]entry) int {
+	latest := 0
+	for version := range versions {
+		if version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
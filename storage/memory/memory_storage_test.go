@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewMemoryStorage()
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+
+	for _, record := range []common.Record{
+		{KeyPath: "b", Version: 1}, {KeyPath: "a/c", Version: 1}, {KeyPath: "a", Version: 1},
+		{KeyPath: "a", Version: 2}, {KeyPath: "a/b", Version: 1}, {KeyPath: "a-b", Version: 1},
+		{KeyPath: "a/b", Version: 3}, {KeyPath: "c", Version: 1},
+	} {
+		if err := m.Store(ctx, record); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+
+	all := []string{"a@2", "a-b@1", "a/b@3", "a/c@1", "b@1", "c@1"}
+	tests := []struct {
+		prefix    string
+		limit     int
+		wantPages int
+		want      []string
+	}{
+		{prefix: "", limit: 100, wantPages: 1, want: all},
+		{prefix: "", limit: 6, wantPages: 1, want: all},
+		{prefix: "", limit: 5, wantPages: 2, want: all},
+		{prefix: "", limit: 1, wantPages: 6, want: all},
+		{prefix: "a", limit: 3, wantPages: 2, want: []string{"a@2", "a-b@1", "a/b@3", "a/c@1"}},
+		{prefix: "a/", limit: 1, wantPages: 2, want: []string{"a/b@3", "a/c@1"}},
+		{prefix: "d", limit: 1, wantPages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"/"+strconv.Itoa(tt.limit), func(t *testing.T) {
+			var got []string
+			token := ""
+			pages := 0
+			for {
+				pages++
+				if pages > tt.wantPages {
+					t.Fatalf("List: got more than %d pages", tt.wantPages)
+				}
+
+				summaries, next, err := m.List(ctx, tt.prefix, tt.limit, token)
+				if err != nil {
+					t.Fatalf("List: %v", err)
+				}
+				if len(summaries) > tt.limit {
+					t.Fatalf("List: got %d summaries, want at most %d", len(summaries), tt.limit)
+				}
+				for _, summary := range summaries {
+					got = append(got, summary.KeyPath+"@"+strconv.Itoa(summary.LatestVersion))
+				}
+				if next == "" {
+					break
+				}
+				token = next
+			}
+
+			if pages != tt.wantPages {
+				t.Errorf("List: got %d pages, want %d", pages, tt.wantPages)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("List: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListInvalidToken(t *testing.T) {
+	m, err := NewMemoryStorage()
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+
+	if _, _, err := m.List(context.Background(), "", 10, "not base64!"); !errors.Is(err, common.ErrInvalidToken) {
+		t.Errorf("List: got error %v, want %v", err, common.ErrInvalidToken)
+	}
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// MirrorStorage implements the Storage interface on top of two other storages. Writes go to the
+// primary and are then repeated on the secondary; reads are served by the primary and fall back
+// to the secondary when the primary fails. A write that fails on the secondary is logged rather
+// than returned, leaving the two storages diverged until Reconcile repairs them.
+type MirrorStorage struct {
+	primary   Storage
+	secondary Storage
+}
+
+// NewMirrorStorage creates a new instance of MirrorStorage.
+func NewMirrorStorage(primary, secondary Storage) *MirrorStorage {
+	return &MirrorStorage{
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+// Migrate migrates both storages.
+func (m *MirrorStorage) Migrate(ctx context.Context) error {
+	if err := m.primary.Migrate(ctx); err != nil {
+		return fmt.Errorf("primary: %v", err)
+	}
+	if err := m.secondary.Migrate(ctx); err != nil {
+		return fmt.Errorf("secondary: %v", err)
+	}
+	return nil
+}
+
+// Ping checks the primary. The secondary is only needed when the primary fails, and writes
+// it misses are repaired by Reconcile, so an unreachable secondary does not fail the check.
+func (m *MirrorStorage) Ping(ctx context.Context) error {
+	return m.primary.Ping(ctx)
+}
+
+// Store stores the record in the primary and then in the secondary.
+func (m *MirrorStorage) Store(ctx context.Context, record Record) error {
+	if err := m.primary.Store(ctx, record); err != nil {
+		return err
+	}
+	m.mirrored("store", record.KeyPath, record.Version, m.secondary.Store(ctx, record))
+	return nil
+}
+
+// BatchStore stores the records in the primary and then the ones it accepted in the secondary.
+func (m *MirrorStorage) BatchStore(ctx context.Context, records []Record) ([]error, error) {
+	errs, err := m.primary.BatchStore(ctx, records)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []Record
+	for i, record := range records {
+		if errs[i] == nil {
+			stored = append(stored, record)
+		}
+	}
+
+	secondaryErrs, err := m.secondary.BatchStore(ctx, stored)
+	if err != nil {
+		log.Printf("mirror: batch store on secondary failed: %v", err)
+		return errs, nil
+	}
+	for i, record := range stored {
+		m.mirrored("store", record.KeyPath, record.Version, secondaryErrs[i])
+	}
+	return errs, nil
+}
+
+// StoreAtomic stores the records atomically in the primary and then in the secondary.
+func (m *MirrorStorage) StoreAtomic(ctx context.Context, records []Record) error {
+	if err := m.primary.StoreAtomic(ctx, records); err != nil {
+		return err
+	}
+	if err := m.secondary.StoreAtomic(ctx, records); err != nil {
+		log.Printf("mirror: atomic store of %d records on secondary failed: %v", len(records), err)
+	}
+	return nil
+}
+
+// Retrieve retrieves the value from the primary, or from the secondary if the primary fails.
+func (m *MirrorStorage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	contents, hmac, kpId, err := m.primary.Retrieve(ctx, keyPath, version)
+	if !m.fallback("retrieve", err) {
+		return contents, hmac, kpId, err
+	}
+	return m.secondary.Retrieve(ctx, keyPath, version)
+}
+
+// BatchRetrieve retrieves the versions from the primary, or from the secondary if the primary fails.
+func (m *MirrorStorage) BatchRetrieve(ctx context.Context, keys []VersionKey) ([]RetrieveResult, error) {
+	results, err := m.primary.BatchRetrieve(ctx, keys)
+	if !m.fallback("batch retrieve", err) {
+		return results, err
+	}
+	return m.secondary.BatchRetrieve(ctx, keys)
+}
+
+// LatestVersion returns the latest version from the primary, or from the secondary if the primary fails.
+func (m *MirrorStorage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	version, err := m.primary.LatestVersion(ctx, keyPath)
+	if !m.fallback("latest version", err) {
+		return version, err
+	}
+	return m.secondary.LatestVersion(ctx, keyPath)
+}
+
+// BatchLatestVersion returns the latest versions from the primary, or from the secondary if the primary fails.
+func (m *MirrorStorage) BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	latest, err := m.primary.BatchLatestVersion(ctx, keyPaths)
+	if !m.fallback("batch latest version", err) {
+		return latest, err
+	}
+	return m.secondary.BatchLatestVersion(ctx, keyPaths)
+}
+
+// Versions describes the versions from the primary, or from the secondary if the primary fails.
+func (m *MirrorStorage) Versions(ctx context.Context, keyPath string) ([]VersionInfo, error) {
+	versions, err := m.primary.Versions(ctx, keyPath)
+	if !m.fallback("versions", err) {
+		return versions, err
+	}
+	return m.secondary.Versions(ctx, keyPath)
+}
+
+// Export exports the versions from the primary, or from the secondary if the primary fails.
+func (m *MirrorStorage) Export(ctx context.Context, keyPath string) ([]Record, error) {
+	records, err := m.primary.Export(ctx, keyPath)
+	if !m.fallback("export", err) {
+		return records, err
+	}
+	return m.secondary.Export(ctx, keyPath)
+}
+
+// List lists key paths from the primary. Continuation tokens are specific to a backend,
+// so only a listing that starts from the first page falls back to the secondary.
+func (m *MirrorStorage) List(ctx context.Context, prefix string, limit int, token string) ([]KeySummary, string, error) {
+	summaries, next, err := m.primary.List(ctx, prefix, limit, token)
+	if token != "" || !m.fallback("list", err) {
+		return summaries, next, err
+	}
+	return m.secondary.List(ctx, prefix, limit, token)
+}
+
+// Delete soft-deletes the version in the primary and then in the secondary.
+func (m *MirrorStorage) Delete(ctx context.Context, keyPath string, version int) error {
+	return m.update(ctx, "delete", keyPath, version, Storage.Delete)
+}
+
+// Undelete recovers the version in the primary and then in the secondary.
+func (m *MirrorStorage) Undelete(ctx context.Context, keyPath string, version int) error {
+	return m.update(ctx, "undelete", keyPath, version, Storage.Undelete)
+}
+
+// Destroy erases the version in the primary and then in the secondary.
+func (m *MirrorStorage) Destroy(ctx context.Context, keyPath string, version int) error {
+	return m.update(ctx, "destroy", keyPath, version, Storage.Destroy)
+}
+
+// Prune prunes the version in the primary and then in the secondary.
+func (m *MirrorStorage) Prune(ctx context.Context, keyPath string, version int) error {
+	return m.update(ctx, "prune", keyPath, version, Storage.Prune)
+}
+
+// UpdateKPId replaces the key provider blob in the primary and then in the secondary.
+func (m *MirrorStorage) UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error {
+	return m.update(ctx, "kp_id update", keyPath, version, func(s Storage, ctx context.Context, keyPath string, version int) error {
+		return s.UpdateKPId(ctx, keyPath, version, oldKPId, newKPId)
+	})
+}
+
+// update applies a deletion state or key provider blob change to the primary and then to the secondary.
+func (m *MirrorStorage) update(ctx context.Context, op string, keyPath string, version int, fn func(Storage, context.Context, string, int) error) error {
+	if err := fn(m.primary, ctx, keyPath, version); err != nil {
+		return err
+	}
+	m.mirrored(op, keyPath, version, fn(m.secondary, ctx, keyPath, version))
+	return nil
+}
+
+// mirrored logs a write that succeeded on the primary but failed on the secondary.
+func (m *MirrorStorage) mirrored(op string, keyPath string, version int, err error) {
+	if err != nil {
+		log.Printf("mirror: %s of %s version %d on secondary failed: %v", op, keyPath, version, err)
+	}
+}
+
+// fallback reports whether a read should be retried on the secondary. Errors describing the
+// data itself, such as a deleted version, are answers rather than failures.
+func (m *MirrorStorage) fallback(op string, err error) bool {
+	if err == nil || errors.Is(err, ErrVersionDeleted) || errors.Is(err, ErrVersionDestroyed) ||
+		errors.Is(err, ErrVersionNotFound) || errors.Is(err, ErrInvalidToken) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	log.Printf("mirror: %s on primary failed, reading from secondary: %v", op, err)
+	return true
+}
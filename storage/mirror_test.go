@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ngoyal16/owlvault/storage/memory"
+)
+
+var errUnavailable = errors.New("storage unavailable")
+
+// failingStorage fails every write and read it overrides while down is set, standing in for
+// an unreachable backend.
+type failingStorage struct {
+	Storage
+	down bool
+}
+
+func (f *failingStorage) Store(ctx context.Context, record Record) error {
+	if f.down {
+		return errUnavailable
+	}
+	return f.Storage.Store(ctx, record)
+}
+
+func (f *failingStorage) BatchStore(ctx context.Context, records []Record) ([]error, error) {
+	if f.down {
+		return nil, errUnavailable
+	}
+	return f.Storage.BatchStore(ctx, records)
+}
+
+func (f *failingStorage) StoreAtomic(ctx context.Context, records []Record) error {
+	if f.down {
+		return errUnavailable
+	}
+	return f.Storage.StoreAtomic(ctx, records)
+}
+
+func (f *failingStorage) Delete(ctx context.Context, keyPath string, version int) error {
+	if f.down {
+		return errUnavailable
+	}
+	return f.Storage.Delete(ctx, keyPath, version)
+}
+
+func (f *failingStorage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	if f.down {
+		return "", "", "", errUnavailable
+	}
+	return f.Storage.Retrieve(ctx, keyPath, version)
+}
+
+func (f *failingStorage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	if f.down {
+		return 0, errUnavailable
+	}
+	return f.Storage.LatestVersion(ctx, keyPath)
+}
+
+func (f *failingStorage) List(ctx context.Context, prefix string, limit int, token string) ([]KeySummary, string, error) {
+	if f.down {
+		return nil, "", errUnavailable
+	}
+	return f.Storage.List(ctx, prefix, limit, token)
+}
+
+// newTestMirror mirrors two memory storages that can be taken down.
+func newTestMirror(t *testing.T) (*MirrorStorage, *failingStorage, *failingStorage) {
+	t.Helper()
+
+	var storages [2]*failingStorage
+	for i := range storages {
+		m, err := memory.NewMemoryStorage()
+		if err != nil {
+			t.Fatalf("NewMemoryStorage: %v", err)
+		}
+		storages[i] = &failingStorage{Storage: m}
+	}
+	return NewMirrorStorage(storages[0], storages[1]), storages[0], storages[1]
+}
+
+func record(keyPath string, version int, contents string) Record {
+	return Record{KeyPath: keyPath, Version: version, Contents: contents, HMAC: "hmac-" + contents, KPId: "kp"}
+}
+
+func TestMirrorSecondaryWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	mirror, primary, secondary := newTestMirror(t)
+
+	if err := mirror.Store(ctx, record("app/db", 1, "one")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	// Writes the secondary misses still succeed on the primary
+	secondary.down = true
+	if err := mirror.Store(ctx, record("app/db", 2, "two")); err != nil {
+		t.Errorf("Store: %v", err)
+	}
+	if errs, err := mirror.BatchStore(ctx, []Record{record("app/smtp", 1, "mail")}); err != nil || errs[0] != nil {
+		t.Errorf("BatchStore: got %v, %v", errs, err)
+	}
+	if err := mirror.StoreAtomic(ctx, []Record{record("app/cache", 1, "redis")}); err != nil {
+		t.Errorf("StoreAtomic: %v", err)
+	}
+	if err := mirror.Delete(ctx, "app/db", 1); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	secondary.down = false
+
+	latest := []struct {
+		keyPath       string
+		wantPrimary   int
+		wantSecondary int
+	}{
+		{keyPath: "app/db", wantPrimary: 2, wantSecondary: 1},
+		{keyPath: "app/smtp", wantPrimary: 1},
+		{keyPath: "app/cache", wantPrimary: 1},
+	}
+	for _, tt := range latest {
+		if got, _ := primary.LatestVersion(ctx, tt.keyPath); got != tt.wantPrimary {
+			t.Errorf("primary LatestVersion %s: got %d, want %d", tt.keyPath, got, tt.wantPrimary)
+		}
+		if got, _ := secondary.LatestVersion(ctx, tt.keyPath); got != tt.wantSecondary {
+			t.Errorf("secondary LatestVersion %s: got %d, want %d", tt.keyPath, got, tt.wantSecondary)
+		}
+	}
+	if _, _, _, err := primary.Retrieve(ctx, "app/db", 1); !errors.Is(err, ErrVersionDeleted) {
+		t.Errorf("primary Retrieve: got error %v, want %v", err, ErrVersionDeleted)
+	}
+	if _, _, _, err := secondary.Retrieve(ctx, "app/db", 1); err != nil {
+		t.Errorf("secondary Retrieve: got error %v, want the version still live", err)
+	}
+
+	// A failing primary fails the write without touching the secondary
+	primary.down = true
+	if err := mirror.Store(ctx, record("app/db", 3, "three")); !errors.Is(err, errUnavailable) {
+		t.Errorf("Store: got error %v, want %v", err, errUnavailable)
+	}
+	primary.down = false
+	if latest, _ := secondary.LatestVersion(ctx, "app/db"); latest != 1 {
+		t.Errorf("secondary LatestVersion: got %d after a failed write, want 1", latest)
+	}
+}
+
+func TestMirrorReadFallback(t *testing.T) {
+	ctx := context.Background()
+	mirror, primary, secondary := newTestMirror(t)
+
+	if err := mirror.Store(ctx, record("app/db", 1, "one")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := mirror.Delete(ctx, "app/db", 1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := secondary.Store(ctx, record("app/db", 2, "secondary only")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	// An answer about the data is not a failure, so the secondary is not consulted
+	if _, _, _, err := mirror.Retrieve(ctx, "app/db", 1); !errors.Is(err, ErrVersionDeleted) {
+		t.Errorf("Retrieve: got error %v, want %v", err, ErrVersionDeleted)
+	}
+	if latest, err := mirror.LatestVersion(ctx, "app/db"); err != nil || latest != 1 {
+		t.Errorf("LatestVersion: got %d, %v, want 1 from the primary", latest, err)
+	}
+
+	primary.down = true
+	if contents, _, _, err := mirror.Retrieve(ctx, "app/db", 2); err != nil || contents != "secondary only" {
+		t.Errorf("Retrieve: got %q, %v, want the secondary's version", contents, err)
+	}
+	if latest, err := mirror.LatestVersion(ctx, "app/db"); err != nil || latest != 2 {
+		t.Errorf("LatestVersion: got %d, %v, want 2 from the secondary", latest, err)
+	}
+	if summaries, _, err := mirror.List(ctx, "", 10, ""); err != nil || len(summaries) != 1 {
+		t.Errorf("List: got %v, %v, want the secondary's key", summaries, err)
+	}
+
+	// Continuation tokens belong to the primary, so a later page does not fall back
+	if _, _, err := mirror.List(ctx, "", 10, "token"); !errors.Is(err, errUnavailable) {
+		t.Errorf("List: got error %v, want %v", err, errUnavailable)
+	}
+}
@@ -0,0 +1,542 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+// MongoDBStorage implements the Storage interface for MongoDB.
+type MongoDBStorage struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// kvDocument is the shape of a single stored version in the collection.
+type kvDocument struct {
+	KeyPath  string `bson:"key_path"`
+	Version  int    `bson:"version"`
+	Contents string `bson:"contents"`
+	HMAC     string `bson:"hmac"`
+	KPID     string `bson:"kp_id"`
+
+	CreatedAt   time.Time `bson:"created_at,omitempty"`
+	Encryptor   string    `bson:"encryptor,omitempty"`
+	KeyProvider string    `bson:"key_provider,omitempty"`
+	CreatedBy   string    `bson:"created_by,omitempty"`
+
+	DeletedAt   *time.Time `bson:"deleted_at,omitempty"`
+	DestroyedAt *time.Time `bson:"destroyed_at,omitempty"`
+	PrunedAt    *time.Time `bson:"pruned_at,omitempty"`
+}
+
+// NewMongoDBStorage creates a new instance of MongoDBStorage.
+func NewMongoDBStorage(connectionString string, databaseName string, collectionName string) (*MongoDBStorage, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connectionString))
+	if err != nil {
+		return nil, err
+	}
+
+	// Check database connection
+	if err := client.Ping(context.Background(), nil); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	return &MongoDBStorage{
+		client:     client,
+		collection: client.Database(databaseName).Collection(collectionName),
+	}, nil
+}
+
+// Migrate creates the (key_path, version) index used for lookups and version ordering.
+func (m *MongoDBStorage) Migrate(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key_path", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetName("key_path_version").SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to run migrations: %v", err)
+	}
+	return nil
+}
+
+// Ping checks that the database is reachable.
+func (m *MongoDBStorage) Ping(ctx context.Context) error {
+	if err := m.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("failed to ping database: %v", err)
+	}
+	return nil
+}
+
+// Store stores the record with its metadata.
+func (m *MongoDBStorage) Store(ctx context.Context, record common.Record) error {
+	_, err := m.collection.InsertOne(ctx, newDocument(record))
+	if mongo.IsDuplicateKeyError(err) {
+		return common.ErrVersionConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store document: %v", err)
+	}
+	return nil
+}
+
+// Retrieve retrieves the value for the specified key and version.
+func (m *MongoDBStorage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	var doc kvDocument
+	err := m.collection.FindOne(ctx, bson.D{
+		{Key: "key_path", Value: keyPath},
+		{Key: "version", Value: version},
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", "", "", nil
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to retrieve document: %v", err)
+	}
+	if err := common.StateError(doc.DeletedAt, doc.DestroyedAt); err != nil {
+		return "", "", "", err
+	}
+
+	return doc.Contents, doc.HMAC, doc.KPID, nil
+}
+
+// LatestVersion returns the latest version of the value for the specified key.
+func (m *MongoDBStorage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	var doc kvDocument
+	err := m.collection.FindOne(ctx,
+		bson.D{{Key: "key_path", Value: keyPath}},
+		options.FindOne().
+			SetSort(bson.D{{Key: "version", Value: -1}}).
+			SetProjection(bson.D{{Key: "version", Value: 1}}),
+	).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query latest version: %v", err)
+	}
+
+	return doc.Version, nil
+}
+
+// BatchStore inserts the records with a single unordered InsertMany, so that a conflicting
+// version does not stop the others from being stored.
+func (m *MongoDBStorage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	errs := make([]error, len(records))
+	if len(records) == 0 {
+		return errs, nil
+	}
+
+	docs := make([]interface{}, len(records))
+	for i, record := range records {
+		docs[i] = newDocument(record)
+	}
+
+	_, err := m.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return errs, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return nil, fmt.Errorf("failed to store documents: %v", err)
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if mongo.IsDuplicateKeyError(writeErr) {
+			errs[writeErr.Index] = common.ErrVersionConflict
+		} else {
+			errs[writeErr.Index] = fmt.Errorf("failed to store document: %v", writeErr)
+		}
+	}
+	return errs, nil
+}
+
+// StoreAtomic inserts the records in a multi-document transaction. MongoDB only supports
+// transactions on replica sets and sharded clusters, not on standalone servers.
+func (m *MongoDBStorage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(records))
+	for i, record := range records {
+		docs[i] = newDocument(record)
+	}
+
+	session, err := m.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return m.collection.InsertMany(sc, docs)
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return common.ErrVersionConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store documents: %v", err)
+	}
+	return nil
+}
+
+// BatchRetrieve fetches the versions with a single query.
+func (m *MongoDBStorage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	results := make([]common.RetrieveResult, len(keys))
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	filters := make(bson.A, len(keys))
+	for i, key := range keys {
+		filters[i] = bson.D{{Key: "key_path", Value: key.KeyPath}, {Key: "version", Value: key.Version}}
+	}
+
+	cursor, err := m.collection.Find(ctx, bson.D{{Key: "$or", Value: filters}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve documents: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	found := make(map[common.VersionKey]common.RetrieveResult, len(keys))
+	for cursor.Next(ctx) {
+		var doc kvDocument
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		result := common.RetrieveResult{Contents: doc.Contents, HMAC: doc.HMAC, KPId: doc.KPID}
+		if err := common.StateError(doc.DeletedAt, doc.DestroyedAt); err != nil {
+			result = common.RetrieveResult{Err: err}
+		}
+		found[common.VersionKey{KeyPath: doc.KeyPath, Version: doc.Version}] = result
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		results[i] = found[key]
+	}
+	return results, nil
+}
+
+// BatchLatestVersion returns the latest version of each key path with a single aggregation.
+func (m *MongoDBStorage) BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	latest := make(map[string]int, len(keyPaths))
+	if len(keyPaths) == 0 {
+		return latest, nil
+	}
+
+	cursor, err := m.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "key_path", Value: bson.D{{Key: "$in", Value: keyPaths}}}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$key_path"},
+			{Key: "latest_version", Value: bson.D{{Key: "$max", Value: "$version"}}},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest versions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var group struct {
+			KeyPath       string `bson:"_id"`
+			LatestVersion int    `bson:"latest_version"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return nil, err
+		}
+		latest[group.KeyPath] = group.LatestVersion
+	}
+
+	return latest, cursor.Err()
+}
+
+// Versions describes every stored version of the key path in ascending order.
+func (m *MongoDBStorage) Versions(ctx context.Context, keyPath string) ([]common.VersionInfo, error) {
+	cursor, err := m.collection.Find(ctx,
+		bson.D{{Key: "key_path", Value: keyPath}},
+		options.Find().
+			SetSort(bson.D{{Key: "version", Value: 1}}).
+			SetProjection(bson.D{{Key: "contents", Value: 0}, {Key: "hmac", Value: 0}, {Key: "kp_id", Value: 0}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var versions []common.VersionInfo
+	for cursor.Next(ctx) {
+		var doc kvDocument
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		versions = append(versions, doc.versionInfo())
+	}
+
+	return versions, cursor.Err()
+}
+
+// Export returns every stored version of the key path in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (m *MongoDBStorage) Export(ctx context.Context, keyPath string) ([]common.Record, error) {
+	cursor, err := m.collection.Find(ctx,
+		bson.D{{Key: "key_path", Value: keyPath}},
+		options.Find().SetSort(bson.D{{Key: "version", Value: 1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var records []common.Record
+	for cursor.Next(ctx) {
+		var doc kvDocument
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		records = append(records, doc.record())
+	}
+
+	return records, cursor.Err()
+}
+
+// List returns key paths starting with prefix in key path order, with their latest versions.
+func (m *MongoDBStorage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	after, err := common.DecodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// An anchored prefix regex can use the (key_path, version) index
+	cursor, err := m.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "key_path", Value: bson.D{
+			{Key: "$regex", Value: "^" + regexp.QuoteMeta(prefix)},
+			{Key: "$gt", Value: after},
+		}}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$key_path"},
+			{Key: "latest_version", Value: bson.D{{Key: "$max", Value: "$version"}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit + 1}},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list key paths: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var summaries []common.KeySummary
+	for cursor.Next(ctx) {
+		var group struct {
+			KeyPath       string `bson:"_id"`
+			LatestVersion int    `bson:"latest_version"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return nil, "", err
+		}
+		summaries = append(summaries, common.KeySummary{
+			KeyPath:       group.KeyPath,
+			LatestVersion: group.LatestVersion,
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, "", err
+	}
+
+	summaries, next := common.Page(summaries, limit)
+	return summaries, next, nil
+}
+
+// Delete soft-deletes the specified version.
+func (m *MongoDBStorage) Delete(ctx context.Context, keyPath string, version int) error {
+	res, err := m.collection.UpdateOne(ctx,
+		bson.D{
+			{Key: "key_path", Value: keyPath},
+			{Key: "version", Value: version},
+			{Key: "deleted_at", Value: nil},
+			{Key: "destroyed_at", Value: nil},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: time.Now().UTC()}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete document: %v", err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	return m.explainUnmatched(ctx, keyPath, version)
+}
+
+// Undelete recovers a soft-deleted version.
+func (m *MongoDBStorage) Undelete(ctx context.Context, keyPath string, version int) error {
+	res, err := m.collection.UpdateOne(ctx,
+		bson.D{
+			{Key: "key_path", Value: keyPath},
+			{Key: "version", Value: version},
+			{Key: "destroyed_at", Value: nil},
+		},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "deleted_at", Value: ""}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to undelete document: %v", err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	return m.explainUnmatched(ctx, keyPath, version)
+}
+
+// Destroy permanently erases the contents of the specified version.
+func (m *MongoDBStorage) Destroy(ctx context.Context, keyPath string, version int) error {
+	return m.erase(ctx, keyPath, version, bson.D{
+		{Key: "destroyed_at", Value: time.Now().UTC()},
+	})
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (m *MongoDBStorage) Prune(ctx context.Context, keyPath string, version int) error {
+	now := time.Now().UTC()
+	return m.erase(ctx, keyPath, version, bson.D{
+		{Key: "destroyed_at", Value: now},
+		{Key: "pruned_at", Value: now},
+	})
+}
+
+// erase clears the contents of a version that is not destroyed yet and sets the given timestamps.
+func (m *MongoDBStorage) erase(ctx context.Context, keyPath string, version int, stamps bson.D) error {
+	res, err := m.collection.UpdateOne(ctx,
+		bson.D{
+			{Key: "key_path", Value: keyPath},
+			{Key: "version", Value: version},
+			{Key: "destroyed_at", Value: nil},
+		},
+		bson.D{{Key: "$set", Value: append(bson.D{
+			{Key: "contents", Value: ""},
+			{Key: "hmac", Value: ""},
+			{Key: "kp_id", Value: ""},
+		}, stamps...)}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to destroy document: %v", err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	err = m.explainUnmatched(ctx, keyPath, version)
+	if errors.Is(err, common.ErrVersionDestroyed) {
+		return nil
+	}
+	return err
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (m *MongoDBStorage) UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error {
+	res, err := m.collection.UpdateOne(ctx,
+		bson.D{
+			{Key: "key_path", Value: keyPath},
+			{Key: "version", Value: version},
+			{Key: "destroyed_at", Value: nil},
+			{Key: "kp_id", Value: oldKPId},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "kp_id", Value: newKPId}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update document: %v", err)
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	var doc kvDocument
+	err = m.collection.FindOne(ctx, bson.D{
+		{Key: "key_path", Value: keyPath},
+		{Key: "version", Value: version},
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return common.ErrVersionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retrieve document: %v", err)
+	}
+	if doc.DestroyedAt != nil {
+		return common.ErrVersionDestroyed
+	}
+	if doc.KPID != newKPId {
+		return common.ErrVersionConflict
+	}
+	return nil
+}
+
+// explainUnmatched reports why a deletion state update matched no document. A version
+// that exists and is not destroyed already had the requested state, which is not an error.
+func (m *MongoDBStorage) explainUnmatched(ctx context.Context, keyPath string, version int) error {
+	var doc kvDocument
+	err := m.collection.FindOne(ctx, bson.D{
+		{Key: "key_path", Value: keyPath},
+		{Key: "version", Value: version},
+	}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return common.ErrVersionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retrieve document: %v", err)
+	}
+	if doc.DestroyedAt != nil {
+		return common.ErrVersionDestroyed
+	}
+	return nil
+}
+
+func newDocument(record common.Record) kvDocument {
+	return kvDocument{
+		KeyPath:     record.KeyPath,
+		Version:     record.Version,
+		Contents:    record.Contents,
+		HMAC:        record.HMAC,
+		KPID:        record.KPId,
+		CreatedAt:   record.Metadata.CreatedAt.UTC(),
+		Encryptor:   record.Metadata.Encryptor,
+		KeyProvider: record.Metadata.KeyProvider,
+		CreatedBy:   record.Metadata.CreatedBy,
+	}
+}
+
+func (doc kvDocument) versionInfo() common.VersionInfo {
+	return common.VersionInfo{
+		Version: doc.Version,
+		Metadata: common.Metadata{
+			CreatedAt:   doc.CreatedAt,
+			Encryptor:   doc.Encryptor,
+			KeyProvider: doc.KeyProvider,
+			CreatedBy:   doc.CreatedBy,
+		},
+		DeletedAt:   doc.DeletedAt,
+		DestroyedAt: doc.DestroyedAt,
+		PrunedAt:    doc.PrunedAt,
+	}
+}
+
+func (doc kvDocument) record() common.Record {
+	return common.Record{
+		KeyPath:  doc.KeyPath,
+		Version:  doc.Version,
+		Contents: doc.Contents,
+		HMAC:     doc.HMAC,
+		KPId:     doc.KPID,
+		Metadata: doc.versionInfo().Metadata,
+	}
+}
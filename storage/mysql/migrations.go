@@ -0,0 +1,140 @@
+package mysql
+
+import (
+	"fmt"
+
+	"github.com/ngoyal16/owlvault/storage/sqlmigrate"
+)
+
+// migrations is the ordered schema history of the MySQL backend.
+// Append new entries; never edit or renumber one that has shipped.
+var migrations = []sqlmigrate.Migration{
+	{
+		Version:     1,
+		Description: "create kv_store table",
+		Up: sqlmigrate.SQL(`
+            CREATE TABLE IF NOT EXISTS kv_store (
+                id INT AUTO_INCREMENT PRIMARY KEY,
+                key_path VARCHAR(255) NOT NULL,
+                contents TEXT NOT NULL,
+                hmac TEXT NOT NULL,
+                kp_id TEXT NOT NULL,
+                version INT NOT NULL
+            )
+        `),
+	},
+	{
+		Version:     2,
+		Description: "add unique index on kv_store (key_path, version)",
+		Up: func(tx sqlmigrate.Execer) error {
+			// Installs predating the migrations table may already carry the index
+			var indexCount int
+			err := tx.QueryRow(`
+                SELECT COUNT(*) FROM information_schema.statistics
+                WHERE table_schema = DATABASE() AND table_name = 'kv_store' AND index_name = 'kv_store_key_path_version'
+            `).Scan(&indexCount)
+			if err != nil || indexCount > 0 {
+				return err
+			}
+
+			if err := removeDuplicateVersions(tx); err != nil {
+				return err
+			}
+			_, err = tx.Exec("ALTER TABLE kv_store ADD UNIQUE KEY kv_store_key_path_version (key_path, version)")
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "add deleted_at and destroyed_at to kv_store",
+		Up: addColumns(
+			column{"deleted_at", "DATETIME(6) NULL DEFAULT NULL"},
+			column{"destroyed_at", "DATETIME(6) NULL DEFAULT NULL"},
+		),
+	},
+	{
+		Version:     4,
+		Description: "add per-version metadata to kv_store",
+		Up: addColumns(
+			column{"created_at", "DATETIME(6) NULL DEFAULT NULL"},
+			column{"encryptor", "VARCHAR(64) NOT NULL DEFAULT ''"},
+			column{"key_provider", "VARCHAR(64) NOT NULL DEFAULT ''"},
+			column{"created_by", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		),
+	},
+	{
+		Version:     5,
+		Description: "add pruned_at to kv_store",
+		Up: addColumns(
+			column{"pruned_at", "DATETIME(6) NULL DEFAULT NULL"},
+		),
+	},
+}
+
+// column is a kv_store column added by a migration.
+type column struct {
+	name       string
+	definition string
+}
+
+// addColumns returns a migration step adding the columns to kv_store. MySQL has no ADD COLUMN
+// IF NOT EXISTS and installs predating the migrations table may already carry some of the
+// columns, so each one is looked up first and only the missing ones are added.
+func addColumns(columns ...column) func(tx sqlmigrate.Execer) error {
+	return func(tx sqlmigrate.Execer) error {
+		for _, c := range columns {
+			var columnCount int
+			err := tx.QueryRow(`
+                SELECT COUNT(*) FROM information_schema.columns
+                WHERE table_schema = DATABASE() AND table_name = 'kv_store' AND column_name = ?
+            `, c.name).Scan(&columnCount)
+			if err != nil {
+				return err
+			}
+			if columnCount > 0 {
+				continue
+			}
+
+			if _, err := tx.Exec("ALTER TABLE kv_store ADD COLUMN " + c.name + " " + c.definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// maxReportedDuplicates bounds how many duplicated versions removeDuplicateVersions names.
+const maxReportedDuplicates = 20
+
+// removeDuplicateVersions prepares kv_store for its unique index on (key_path, version), which
+// concurrent writers may have violated before it existed. Identical copies of a version are
+// dropped, keeping the first row written. Copies with different contents cannot be told apart
+// safely, so they are reported instead and the migration fails until they are resolved.
+func removeDuplicateVersions(tx sqlmigrate.Execer) error {
+	_, err := tx.Exec(`
+        DELETE newer FROM kv_store newer
+        JOIN kv_store older
+            ON newer.key_path = older.key_path AND newer.version = older.version AND newer.id > older.id
+            AND newer.contents = older.contents AND newer.hmac = older.hmac AND newer.kp_id = older.kp_id
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to remove identical duplicate versions: %v", err)
+	}
+
+	var count int
+	var examples string
+	err = tx.QueryRow(`
+        SELECT COUNT(*), COALESCE(SUBSTRING_INDEX(GROUP_CONCAT(CONCAT(key_path, ' version ', version) ORDER BY key_path, version SEPARATOR ', '), ', ', ?), '')
+        FROM (SELECT key_path, version FROM kv_store GROUP BY key_path, version HAVING COUNT(*) > 1) duplicates
+    `, maxReportedDuplicates).Scan(&count, &examples)
+	if err != nil {
+		return fmt.Errorf("failed to check for duplicate versions: %v", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("kv_store holds %d versions that were stored more than once with different contents, "+
+			"so the unique index on (key_path, version) cannot be added: %s. For each of them, decide which row to keep "+
+			"and move the other rows to unused versions above the key path's latest version "+
+			"(UPDATE kv_store SET version = ... WHERE id = ...) or delete them, then run the migration again", count, examples)
+	}
+	return nil
+}
@@ -1,11 +1,25 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql" // Import MySQL driver
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+	"github.com/ngoyal16/owlvault/storage/sqlmigrate"
 )
 
+// errDuplicateEntry is the MySQL error number for a unique key violation.
+const errDuplicateEntry = 1062
+
+// batchSize bounds the rows written or looked up by a single batch statement.
+const batchSize = 500
+
 // MySQLStorage implements the Storage interface for MySQL database.
 type MySQLStorage struct {
 	db *sql.DB
@@ -14,7 +28,14 @@ type MySQLStorage struct {
 
 // NewMySQLStorage creates a new instance of MySQLStorage.
 func NewMySQLStorage(connectionString string) (*MySQLStorage, error) {
-	db, err := sql.Open("mysql", connectionString)
+	// DATETIME and TIMESTAMP columns are scanned into time.Time
+	dsn, err := mysql.ParseDSN(connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %v", err)
+	}
+	dsn.ParseTime = true
+
+	db, err := sql.Open("mysql", dsn.FormatDSN())
 	if err != nil {
 		return nil, err
 	}
@@ -27,45 +48,63 @@ func NewMySQLStorage(connectionString string) (*MySQLStorage, error) {
 	return &MySQLStorage{db: db}, nil
 }
 
-// Migrate runs the necessary database migrations for MySQLStorage.
-func (m *MySQLStorage) Migrate() error {
-	// Implement database migrations specific to MySQL
-	_, err := m.db.Exec(`
-        CREATE TABLE IF NOT EXISTS kv_store (
-            id INT AUTO_INCREMENT PRIMARY KEY,
-            key_path VARCHAR(255) NOT NULL,
-            contents TEXT NOT NULL,
-            hmac TEXT NOT NULL,
-            kp_id TEXT NOT NULL,
-            version INT NOT NULL
-        );
-    `)
-	if err != nil {
+// Migrate applies any pending schema migrations for MySQLStorage.
+func (m *MySQLStorage) Migrate(ctx context.Context) error {
+	if err := sqlmigrate.Run(ctx, m.db, sqlmigrate.MySQL, migrations); err != nil {
 		return fmt.Errorf("failed to run migrations: %v", err)
 	}
 	return nil
 }
 
-// Store stores the key-value pair with the specified version and timestamp.
-func (m *MySQLStorage) Store(key, contents, hmac, kpId string, version int) error {
-	_, err := m.db.Exec("INSERT INTO kv_store (key_path, contents, hmac, kp_id, version) VALUES (?, ?, ?, ?, ?)", key, contents, hmac, kpId, version)
+// MigrationStatus lists the schema migrations known to MySQLStorage and whether they have been applied.
+func (m *MySQLStorage) MigrationStatus(ctx context.Context) ([]sqlmigrate.Status, error) {
+	return sqlmigrate.Statuses(ctx, m.db, sqlmigrate.MySQL, migrations)
+}
+
+// Ping checks that the database is reachable.
+func (m *MySQLStorage) Ping(ctx context.Context) error {
+	if err := m.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %v", err)
+	}
+	return nil
+}
+
+// Store stores the record with its metadata.
+func (m *MySQLStorage) Store(ctx context.Context, record common.Record) error {
+	_, err := m.db.ExecContext(ctx,
+		"INSERT INTO kv_store (key_path, contents, hmac, kp_id, version, created_at, encryptor, key_provider, created_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		record.KeyPath, record.Contents, record.HMAC, record.KPId, record.Version,
+		record.Metadata.CreatedAt.UTC(), record.Metadata.Encryptor, record.Metadata.KeyProvider, record.Metadata.CreatedBy,
+	)
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+		return common.ErrVersionConflict
+	}
 	return err
 }
 
 // Retrieve retrieves the value for the specified key and version.
-func (m *MySQLStorage) Retrieve(key string, version int) (string, string, string, error) {
+func (m *MySQLStorage) Retrieve(ctx context.Context, key string, version int) (string, string, string, error) {
 	var contents, hmac, kpId string
-	err := m.db.QueryRow("SELECT contents, hmac, kp_id FROM kv_store WHERE key_path = ? AND version = ?", key, version).Scan(&contents, &hmac, &kpId)
+	var deletedAt, destroyedAt sql.NullTime
+	err := m.db.QueryRowContext(ctx, "SELECT contents, hmac, kp_id, deleted_at, destroyed_at FROM kv_store WHERE key_path = ? AND version = ?", key, version).Scan(&contents, &hmac, &kpId, &deletedAt, &destroyedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", "", nil
+	}
 	if err != nil {
 		return "", "", "", err
 	}
+	if err := common.StateError(nullTime(deletedAt), nullTime(destroyedAt)); err != nil {
+		return "", "", "", err
+	}
 	return contents, hmac, kpId, nil
 }
 
 // LatestVersion returns the latest version of the value for the specified key.
-func (m *MySQLStorage) LatestVersion(key string) (int, error) {
+func (m *MySQLStorage) LatestVersion(ctx context.Context, key string) (int, error) {
 	var latestVersion sql.NullInt64
-	err := m.db.QueryRow("SELECT MAX(version) FROM kv_store WHERE key_path = ?", key).Scan(&latestVersion)
+	err := m.db.QueryRowContext(ctx, "SELECT MAX(version) FROM kv_store WHERE key_path = ?", key).Scan(&latestVersion)
 	if err != nil {
 		return 0, err
 	}
@@ -76,3 +115,337 @@ func (m *MySQLStorage) LatestVersion(key string) (int, error) {
 
 	return int(latestVersion.Int64), nil
 }
+
+// BatchStore inserts the records with one multi-row INSERT per chunk. MySQL rolls back the
+// whole statement on a duplicate version, so a chunk that conflicts is retried row by row
+// to find out which records conflicted.
+func (m *MySQLStorage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	errs := make([]error, len(records))
+
+	for start := 0; start < len(records); start += batchSize {
+		chunk := records[start:min(start+batchSize, len(records))]
+
+		query, args := insertStatement(chunk)
+		_, err := m.db.ExecContext(ctx, query, args...)
+
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+			for i, record := range chunk {
+				errs[start+i] = m.Store(ctx, record)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return errs, nil
+}
+
+// StoreAtomic inserts the records in a single transaction, rolling it back if any version already exists.
+func (m *MySQLStorage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(records); start += batchSize {
+		query, args := insertStatement(records[start:min(start+batchSize, len(records))])
+		_, err := tx.ExecContext(ctx, query, args...)
+
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+			return common.ErrVersionConflict
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// BatchRetrieve fetches the versions with one query per chunk.
+func (m *MySQLStorage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	found := make(map[common.VersionKey]common.RetrieveResult, len(keys))
+
+	for start := 0; start < len(keys); start += batchSize {
+		chunk := keys[start:min(start+batchSize, len(keys))]
+
+		tuples := make([]string, len(chunk))
+		args := make([]any, 0, len(chunk)*2)
+		for i, key := range chunk {
+			tuples[i] = "(?, ?)"
+			args = append(args, key.KeyPath, key.Version)
+		}
+
+		rows, err := m.db.QueryContext(ctx, "SELECT key_path, version, contents, hmac, kp_id, deleted_at, destroyed_at FROM kv_store WHERE (key_path, version) IN ("+strings.Join(tuples, ", ")+")", args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var key common.VersionKey
+			var result common.RetrieveResult
+			var deletedAt, destroyedAt sql.NullTime
+			if err := rows.Scan(&key.KeyPath, &key.Version, &result.Contents, &result.HMAC, &result.KPId, &deletedAt, &destroyedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if err := common.StateError(nullTime(deletedAt), nullTime(destroyedAt)); err != nil {
+				result = common.RetrieveResult{Err: err}
+			}
+			found[key] = result
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]common.RetrieveResult, len(keys))
+	for i, key := range keys {
+		results[i] = found[key]
+	}
+	return results, nil
+}
+
+// BatchLatestVersion returns the latest version of each key with one query per chunk.
+func (m *MySQLStorage) BatchLatestVersion(ctx context.Context, keys []string) (map[string]int, error) {
+	latest := make(map[string]int, len(keys))
+
+	for start := 0; start < len(keys); start += batchSize {
+		chunk := keys[start:min(start+batchSize, len(keys))]
+
+		placeholders := make([]string, len(chunk))
+		args := make([]any, len(chunk))
+		for i, key := range chunk {
+			placeholders[i] = "?"
+			args[i] = key
+		}
+
+		rows, err := m.db.QueryContext(ctx, "SELECT key_path, MAX(version) FROM kv_store WHERE key_path IN ("+strings.Join(placeholders, ", ")+") GROUP BY key_path", args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var key string
+			var version int
+			if err := rows.Scan(&key, &version); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			latest[key] = version
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return latest, nil
+}
+
+// Versions describes every stored version of the key in ascending order.
+func (m *MySQLStorage) Versions(ctx context.Context, key string) ([]common.VersionInfo, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, created_at, encryptor, key_provider, created_by, deleted_at, destroyed_at, pruned_at FROM kv_store WHERE key_path = ? ORDER BY version", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []common.VersionInfo
+	for rows.Next() {
+		var info common.VersionInfo
+		var createdAt, deletedAt, destroyedAt, prunedAt sql.NullTime
+		err := rows.Scan(&info.Version, &createdAt, &info.Metadata.Encryptor, &info.Metadata.KeyProvider, &info.Metadata.CreatedBy, &deletedAt, &destroyedAt, &prunedAt)
+		if err != nil {
+			return nil, err
+		}
+		info.Metadata.CreatedAt = createdAt.Time
+		info.DeletedAt = nullTime(deletedAt)
+		info.DestroyedAt = nullTime(destroyedAt)
+		info.PrunedAt = nullTime(prunedAt)
+		versions = append(versions, info)
+	}
+
+	return versions, rows.Err()
+}
+
+// Export returns every stored version of the key in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (m *MySQLStorage) Export(ctx context.Context, key string) ([]common.Record, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, contents, hmac, kp_id, created_at, encryptor, key_provider, created_by FROM kv_store WHERE key_path = ? ORDER BY version", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []common.Record
+	for rows.Next() {
+		record := common.Record{KeyPath: key}
+		var createdAt sql.NullTime
+		err := rows.Scan(&record.Version, &record.Contents, &record.HMAC, &record.KPId, &createdAt, &record.Metadata.Encryptor, &record.Metadata.KeyProvider, &record.Metadata.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		record.Metadata.CreatedAt = createdAt.Time
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// List returns key paths starting with prefix in key path order, with their latest versions.
+func (m *MySQLStorage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	after, err := common.DecodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := m.db.QueryContext(ctx, "SELECT key_path, MAX(version) FROM kv_store WHERE key_path LIKE ? AND key_path > ? GROUP BY key_path ORDER BY key_path LIMIT ?", common.EscapeLike(prefix)+"%", after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var summaries []common.KeySummary
+	for rows.Next() {
+		var summary common.KeySummary
+		if err := rows.Scan(&summary.KeyPath, &summary.LatestVersion); err != nil {
+			return nil, "", err
+		}
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	summaries, next := common.Page(summaries, limit)
+	return summaries, next, nil
+}
+
+// Delete soft-deletes the specified version.
+func (m *MySQLStorage) Delete(ctx context.Context, key string, version int) error {
+	res, err := m.db.ExecContext(ctx, "UPDATE kv_store SET deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP(6)) WHERE key_path = ? AND version = ? AND destroyed_at IS NULL", key, version)
+	return m.checkUpdated(ctx, res, err, key, version)
+}
+
+// Undelete recovers a soft-deleted version.
+func (m *MySQLStorage) Undelete(ctx context.Context, key string, version int) error {
+	res, err := m.db.ExecContext(ctx, "UPDATE kv_store SET deleted_at = NULL WHERE key_path = ? AND version = ? AND destroyed_at IS NULL", key, version)
+	return m.checkUpdated(ctx, res, err, key, version)
+}
+
+// Destroy permanently erases the contents of the specified version.
+func (m *MySQLStorage) Destroy(ctx context.Context, key string, version int) error {
+	res, err := m.db.ExecContext(ctx, "UPDATE kv_store SET contents = '', hmac = '', kp_id = '', destroyed_at = COALESCE(destroyed_at, CURRENT_TIMESTAMP(6)) WHERE key_path = ? AND version = ?", key, version)
+	return m.checkErased(ctx, res, err, key, version)
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (m *MySQLStorage) Prune(ctx context.Context, key string, version int) error {
+	res, err := m.db.ExecContext(ctx, "UPDATE kv_store SET contents = '', hmac = '', kp_id = '', destroyed_at = COALESCE(destroyed_at, CURRENT_TIMESTAMP(6)), pruned_at = COALESCE(pruned_at, CURRENT_TIMESTAMP(6)) WHERE key_path = ? AND version = ?", key, version)
+	return m.checkErased(ctx, res, err, key, version)
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (m *MySQLStorage) UpdateKPId(ctx context.Context, key string, version int, oldKPId, newKPId string) error {
+	res, err := m.db.ExecContext(ctx, "UPDATE kv_store SET kp_id = ? WHERE key_path = ? AND version = ? AND kp_id = ? AND destroyed_at IS NULL", newKPId, key, version, oldKPId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var kpId string
+	var destroyedAt sql.NullTime
+	err = m.db.QueryRowContext(ctx, "SELECT kp_id, destroyed_at FROM kv_store WHERE key_path = ? AND version = ?", key, version).Scan(&kpId, &destroyedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.ErrVersionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if destroyedAt.Valid {
+		return common.ErrVersionDestroyed
+	}
+	if kpId != newKPId {
+		return common.ErrVersionConflict
+	}
+	// The row already held the new blob
+	return nil
+}
+
+// checkErased explains why erasing a version matched no rows.
+func (m *MySQLStorage) checkErased(ctx context.Context, res sql.Result, err error, key string, version int) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// MySQL reports unchanged rows as unaffected, so an already erased version lands here too
+	_, err = m.destroyedAt(ctx, key, version)
+	return err
+}
+
+// checkUpdated explains why a deletion state update matched no rows.
+func (m *MySQLStorage) checkUpdated(ctx context.Context, res sql.Result, err error, key string, version int) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	destroyedAt, err := m.destroyedAt(ctx, key, version)
+	if err != nil {
+		return err
+	}
+	if destroyedAt != nil {
+		return common.ErrVersionDestroyed
+	}
+	// The row exists but already had the requested state
+	return nil
+}
+
+// destroyedAt returns when the version was destroyed, or ErrVersionNotFound if it does not exist.
+func (m *MySQLStorage) destroyedAt(ctx context.Context, key string, version int) (*time.Time, error) {
+	var destroyedAt sql.NullTime
+	err := m.db.QueryRowContext(ctx, "SELECT destroyed_at FROM kv_store WHERE key_path = ? AND version = ?", key, version).Scan(&destroyedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, common.ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nullTime(destroyedAt), nil
+}
+
+// insertStatement builds a multi-row INSERT of the records.
+func insertStatement(records []common.Record) (string, []any) {
+	rows := make([]string, len(records))
+	args := make([]any, 0, len(records)*9)
+	for i, record := range records {
+		rows[i] = "(?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args,
+			record.KeyPath, record.Contents, record.HMAC, record.KPId, record.Version,
+			record.Metadata.CreatedAt.UTC(), record.Metadata.Encryptor, record.Metadata.KeyProvider, record.Metadata.CreatedBy,
+		)
+	}
+	return "INSERT INTO kv_store (key_path, contents, hmac, kp_id, version, created_at, encryptor, key_provider, created_by) VALUES " + strings.Join(rows, ", "), args
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
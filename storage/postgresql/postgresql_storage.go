@@ -0,0 +1,429 @@
+package postgresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+	"github.com/ngoyal16/owlvault/storage/sqlmigrate"
+)
+
+// errUniqueViolation is the PostgreSQL error code for a unique constraint violation.
+const errUniqueViolation = "23505"
+
+// batchSize bounds the rows written or looked up by a single batch statement.
+const batchSize = 500
+
+// PostgreSQLStorage implements the Storage interface for PostgreSQL database.
+type PostgreSQLStorage struct {
+	db *sql.DB
+}
+
+// NewPostgreSQLStorage creates a new instance of PostgreSQLStorage.
+func NewPostgreSQLStorage(connectionString string) (*PostgreSQLStorage, error) {
+	db, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check database connection
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	return &PostgreSQLStorage{db: db}, nil
+}
+
+// Migrate applies any pending schema migrations for PostgreSQLStorage.
+func (p *PostgreSQLStorage) Migrate(ctx context.Context) error {
+	if err := sqlmigrate.Run(ctx, p.db, sqlmigrate.PostgreSQL, migrations); err != nil {
+		return fmt.Errorf("failed to run migrations: %v", err)
+	}
+	return nil
+}
+
+// MigrationStatus lists the schema migrations known to PostgreSQLStorage and whether they have been applied.
+func (p *PostgreSQLStorage) MigrationStatus(ctx context.Context) ([]sqlmigrate.Status, error) {
+	return sqlmigrate.Statuses(ctx, p.db, sqlmigrate.PostgreSQL, migrations)
+}
+
+// Ping checks that the database is reachable.
+func (p *PostgreSQLStorage) Ping(ctx context.Context) error {
+	if err := p.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %v", err)
+	}
+	return nil
+}
+
+// Store stores the record with its metadata.
+func (p *PostgreSQLStorage) Store(ctx context.Context, record common.Record) error {
+	_, err := p.db.ExecContext(ctx,
+		"INSERT INTO kv_store (key_path, contents, hmac, kp_id, version, created_at, encryptor, key_provider, created_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		record.KeyPath, record.Contents, record.HMAC, record.KPId, record.Version,
+		record.Metadata.CreatedAt.UTC(), record.Metadata.Encryptor, record.Metadata.KeyProvider, record.Metadata.CreatedBy,
+	)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == errUniqueViolation {
+		return common.ErrVersionConflict
+	}
+	return err
+}
+
+// Retrieve retrieves the value for the specified key and version.
+// A missing version yields empty values, matching the other backends.
+func (p *PostgreSQLStorage) Retrieve(ctx context.Context, key string, version int) (string, string, string, error) {
+	var contents, hmac, kpId string
+	var deletedAt, destroyedAt sql.NullTime
+	err := p.db.QueryRowContext(ctx, "SELECT contents, hmac, kp_id, deleted_at, destroyed_at FROM kv_store WHERE key_path = $1 AND version = $2", key, version).Scan(&contents, &hmac, &kpId, &deletedAt, &destroyedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", "", nil
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	if err := common.StateError(nullTime(deletedAt), nullTime(destroyedAt)); err != nil {
+		return "", "", "", err
+	}
+	return contents, hmac, kpId, nil
+}
+
+// LatestVersion returns the latest version of the value for the specified key.
+func (p *PostgreSQLStorage) LatestVersion(ctx context.Context, key string) (int, error) {
+	var latestVersion sql.NullInt64
+	err := p.db.QueryRowContext(ctx, "SELECT MAX(version) FROM kv_store WHERE key_path = $1", key).Scan(&latestVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	if !latestVersion.Valid {
+		return 0, nil
+	}
+
+	return int(latestVersion.Int64), nil
+}
+
+// BatchStore inserts the records with one multi-row INSERT per chunk. Conflicting versions
+// are skipped by ON CONFLICT DO NOTHING and recognised by their absence from RETURNING.
+func (p *PostgreSQLStorage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	errs := make([]error, len(records))
+
+	for start := 0; start < len(records); start += batchSize {
+		chunk := records[start:min(start+batchSize, len(records))]
+
+		query, args := insertStatement(chunk)
+		result, err := p.db.QueryContext(ctx, query+" ON CONFLICT (key_path, version) DO NOTHING RETURNING key_path, version", args...)
+		if err != nil {
+			return nil, err
+		}
+
+		inserted := make(map[common.VersionKey]bool, len(chunk))
+		for result.Next() {
+			var key common.VersionKey
+			if err := result.Scan(&key.KeyPath, &key.Version); err != nil {
+				result.Close()
+				return nil, err
+			}
+			inserted[key] = true
+		}
+		result.Close()
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+
+		for i, record := range chunk {
+			if !inserted[common.VersionKey{KeyPath: record.KeyPath, Version: record.Version}] {
+				errs[start+i] = common.ErrVersionConflict
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// StoreAtomic inserts the records in a single transaction, rolling it back if any version already exists.
+func (p *PostgreSQLStorage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(records); start += batchSize {
+		query, args := insertStatement(records[start:min(start+batchSize, len(records))])
+		_, err := tx.ExecContext(ctx, query, args...)
+
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == errUniqueViolation {
+			return common.ErrVersionConflict
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// BatchRetrieve fetches the versions with one query per chunk.
+func (p *PostgreSQLStorage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	found := make(map[common.VersionKey]common.RetrieveResult, len(keys))
+
+	for start := 0; start < len(keys); start += batchSize {
+		chunk := keys[start:min(start+batchSize, len(keys))]
+
+		tuples := make([]string, len(chunk))
+		args := make([]any, 0, len(chunk)*2)
+		for i, key := range chunk {
+			tuples[i] = fmt.Sprintf("($%d, $%d)", len(args)+1, len(args)+2)
+			args = append(args, key.KeyPath, key.Version)
+		}
+
+		rows, err := p.db.QueryContext(ctx, "SELECT key_path, version, contents, hmac, kp_id, deleted_at, destroyed_at FROM kv_store WHERE (key_path, version) IN ("+strings.Join(tuples, ", ")+")", args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var key common.VersionKey
+			var result common.RetrieveResult
+			var deletedAt, destroyedAt sql.NullTime
+			if err := rows.Scan(&key.KeyPath, &key.Version, &result.Contents, &result.HMAC, &result.KPId, &deletedAt, &destroyedAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if err := common.StateError(nullTime(deletedAt), nullTime(destroyedAt)); err != nil {
+				result = common.RetrieveResult{Err: err}
+			}
+			found[key] = result
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]common.RetrieveResult, len(keys))
+	for i, key := range keys {
+		results[i] = found[key]
+	}
+	return results, nil
+}
+
+// BatchLatestVersion returns the latest version of each key with a single query.
+func (p *PostgreSQLStorage) BatchLatestVersion(ctx context.Context, keys []string) (map[string]int, error) {
+	latest := make(map[string]int, len(keys))
+	if len(keys) == 0 {
+		return latest, nil
+	}
+
+	rows, err := p.db.QueryContext(ctx, "SELECT key_path, MAX(version) FROM kv_store WHERE key_path = ANY($1) GROUP BY key_path", pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var version int
+		if err := rows.Scan(&key, &version); err != nil {
+			return nil, err
+		}
+		latest[key] = version
+	}
+
+	return latest, rows.Err()
+}
+
+// Versions describes every stored version of the key in ascending order.
+func (p *PostgreSQLStorage) Versions(ctx context.Context, key string) ([]common.VersionInfo, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT version, created_at, encryptor, key_provider, created_by, deleted_at, destroyed_at, pruned_at FROM kv_store WHERE key_path = $1 ORDER BY version", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []common.VersionInfo
+	for rows.Next() {
+		var info common.VersionInfo
+		var createdAt, deletedAt, destroyedAt, prunedAt sql.NullTime
+		err := rows.Scan(&info.Version, &createdAt, &info.Metadata.Encryptor, &info.Metadata.KeyProvider, &info.Metadata.CreatedBy, &deletedAt, &destroyedAt, &prunedAt)
+		if err != nil {
+			return nil, err
+		}
+		info.Metadata.CreatedAt = createdAt.Time
+		info.DeletedAt = nullTime(deletedAt)
+		info.DestroyedAt = nullTime(destroyedAt)
+		info.PrunedAt = nullTime(prunedAt)
+		versions = append(versions, info)
+	}
+
+	return versions, rows.Err()
+}
+
+// Export returns every stored version of the key in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (p *PostgreSQLStorage) Export(ctx context.Context, key string) ([]common.Record, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT version, contents, hmac, kp_id, created_at, encryptor, key_provider, created_by FROM kv_store WHERE key_path = $1 ORDER BY version", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []common.Record
+	for rows.Next() {
+		record := common.Record{KeyPath: key}
+		var createdAt sql.NullTime
+		err := rows.Scan(&record.Version, &record.Contents, &record.HMAC, &record.KPId, &createdAt, &record.Metadata.Encryptor, &record.Metadata.KeyProvider, &record.Metadata.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		record.Metadata.CreatedAt = createdAt.Time
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// List returns key paths starting with prefix in key path order, with their latest versions.
+func (p *PostgreSQLStorage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	after, err := common.DecodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	rows, err := p.db.QueryContext(ctx, "SELECT key_path, MAX(version) FROM kv_store WHERE key_path LIKE $1 AND key_path > $2 GROUP BY key_path ORDER BY key_path LIMIT $3", common.EscapeLike(prefix)+"%", after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var summaries []common.KeySummary
+	for rows.Next() {
+		var summary common.KeySummary
+		if err := rows.Scan(&summary.KeyPath, &summary.LatestVersion); err != nil {
+			return nil, "", err
+		}
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	summaries, next := common.Page(summaries, limit)
+	return summaries, next, nil
+}
+
+// Delete soft-deletes the specified version.
+func (p *PostgreSQLStorage) Delete(ctx context.Context, key string, version int) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE kv_store SET deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP) WHERE key_path = $1 AND version = $2 AND destroyed_at IS NULL", key, version)
+	return p.checkUpdated(ctx, res, err, key, version)
+}
+
+// Undelete recovers a soft-deleted version.
+func (p *PostgreSQLStorage) Undelete(ctx context.Context, key string, version int) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE kv_store SET deleted_at = NULL WHERE key_path = $1 AND version = $2 AND destroyed_at IS NULL", key, version)
+	return p.checkUpdated(ctx, res, err, key, version)
+}
+
+// Destroy permanently erases the contents of the specified version.
+func (p *PostgreSQLStorage) Destroy(ctx context.Context, key string, version int) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE kv_store SET contents = '', hmac = '', kp_id = '', destroyed_at = COALESCE(destroyed_at, CURRENT_TIMESTAMP) WHERE key_path = $1 AND version = $2", key, version)
+	return checkErased(res, err)
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (p *PostgreSQLStorage) Prune(ctx context.Context, key string, version int) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE kv_store SET contents = '', hmac = '', kp_id = '', destroyed_at = COALESCE(destroyed_at, CURRENT_TIMESTAMP), pruned_at = COALESCE(pruned_at, CURRENT_TIMESTAMP) WHERE key_path = $1 AND version = $2", key, version)
+	return checkErased(res, err)
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (p *PostgreSQLStorage) UpdateKPId(ctx context.Context, key string, version int, oldKPId, newKPId string) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE kv_store SET kp_id = $1 WHERE key_path = $2 AND version = $3 AND kp_id = $4 AND destroyed_at IS NULL", newKPId, key, version, oldKPId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var kpId string
+	var destroyedAt sql.NullTime
+	err = p.db.QueryRowContext(ctx, "SELECT kp_id, destroyed_at FROM kv_store WHERE key_path = $1 AND version = $2", key, version).Scan(&kpId, &destroyedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.ErrVersionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if destroyedAt.Valid {
+		return common.ErrVersionDestroyed
+	}
+	if kpId != newKPId {
+		return common.ErrVersionConflict
+	}
+	// The row already held the new blob
+	return nil
+}
+
+// checkErased reports ErrVersionNotFound when erasing a version matched no rows.
+func checkErased(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return common.ErrVersionNotFound
+}
+
+// checkUpdated explains why a deletion state update matched no rows.
+func (p *PostgreSQLStorage) checkUpdated(ctx context.Context, res sql.Result, err error, key string, version int) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var destroyedAt sql.NullTime
+	err = p.db.QueryRowContext(ctx, "SELECT destroyed_at FROM kv_store WHERE key_path = $1 AND version = $2", key, version).Scan(&destroyedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.ErrVersionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return common.ErrVersionDestroyed
+}
+
+// insertStatement builds a multi-row INSERT of the records.
+func insertStatement(records []common.Record) (string, []any) {
+	rows := make([]string, len(records))
+	args := make([]any, 0, len(records)*9)
+	for i, record := range records {
+		n := len(args)
+		rows[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9)
+		args = append(args,
+			record.KeyPath, record.Contents, record.HMAC, record.KPId, record.Version,
+			record.Metadata.CreatedAt.UTC(), record.Metadata.Encryptor, record.Metadata.KeyProvider, record.Metadata.CreatedBy,
+		)
+	}
+	return "INSERT INTO kv_store (key_path, contents, hmac, kp_id, version, created_at, encryptor, key_provider, created_by) VALUES " + strings.Join(rows, ", "), args
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
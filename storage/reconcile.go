@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// reconcilePageSize is the number of key paths compared per List call during reconciliation.
+const reconcilePageSize = 100
+
+// ReconcileReport describes how the secondary of a MirrorStorage differs from its primary.
+type ReconcileReport struct {
+	// Keys is the number of key paths compared.
+	Keys int
+	// Missing lists versions held by the primary but not by the secondary.
+	Missing []VersionKey
+	// StateDiverged lists versions whose deletion state differs between the storages.
+	StateDiverged []VersionKey
+	// Conflicts lists versions the secondary holds with different contents, or has erased while
+	// the primary has not. They are never repaired automatically.
+	Conflicts []VersionKey
+	// SecondaryOnly lists versions held by the secondary but not by the primary. They are never
+	// repaired automatically.
+	SecondaryOnly []VersionKey
+	// Repaired is the number of Missing and StateDiverged versions fixed on the secondary.
+	Repaired int
+}
+
+// Reconcile compares every version under prefix in both storages, treating the primary as the
+// source of truth. With repair set, versions missing from the secondary are copied to it as
+// stored and diverged deletion states are replayed on it; otherwise the storages are only compared.
+func (m *MirrorStorage) Reconcile(ctx context.Context, prefix string, repair bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{}
+
+	token := ""
+	for {
+		summaries, next, err := m.primary.List(ctx, prefix, reconcilePageSize, token)
+		if err != nil {
+			return report, fmt.Errorf("failed to list primary keys: %v", err)
+		}
+		for _, summary := range summaries {
+			if err := m.reconcileKey(ctx, summary.KeyPath, repair, report); err != nil {
+				return report, fmt.Errorf("%s: %v", summary.KeyPath, err)
+			}
+			report.Keys++
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	// Keys that only exist on the secondary are not visited by the primary listing
+	token = ""
+	for {
+		summaries, next, err := m.secondary.List(ctx, prefix, reconcilePageSize, token)
+		if err != nil {
+			return report, fmt.Errorf("failed to list secondary keys: %v", err)
+		}
+		for _, summary := range summaries {
+			latest, err := m.primary.LatestVersion(ctx, summary.KeyPath)
+			if err != nil {
+				return report, fmt.Errorf("%s: %v", summary.KeyPath, err)
+			}
+			if latest != 0 {
+				continue
+			}
+			if err := m.reconcileKey(ctx, summary.KeyPath, repair, report); err != nil {
+				return report, fmt.Errorf("%s: %v", summary.KeyPath, err)
+			}
+			report.Keys++
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	return report, nil
+}
+
+// reconcileKey compares, and optionally repairs, every version of keyPath.
+func (m *MirrorStorage) reconcileKey(ctx context.Context, keyPath string, repair bool, report *ReconcileReport) error {
+	primaryRecords, primaryStates, err := snapshot(ctx, m.primary, keyPath)
+	if err != nil {
+		return fmt.Errorf("primary: %v", err)
+	}
+	secondaryRecords, secondaryStates, err := snapshot(ctx, m.secondary, keyPath)
+	if err != nil {
+		return fmt.Errorf("secondary: %v", err)
+	}
+
+	for _, version := range sortedVersions(primaryRecords) {
+		record := primaryRecords[version]
+		key := VersionKey{KeyPath: keyPath, Version: version}
+		state := primaryStates[version]
+
+		existing, ok := secondaryRecords[version]
+		if !ok {
+			report.Missing = append(report.Missing, key)
+			if repair {
+				if err := m.secondary.Store(ctx, record); err != nil {
+					return fmt.Errorf("version %d: failed to store: %v", version, err)
+				}
+				if err := ReplayState(ctx, m.secondary, keyPath, state, VersionInfo{Version: version}); err != nil {
+					return fmt.Errorf("version %d: %v", version, err)
+				}
+				report.Repaired++
+			}
+			continue
+		}
+
+		secondaryState := secondaryStates[version]
+		if (secondaryState.DestroyedAt != nil && state.DestroyedAt == nil) || (secondaryState.PrunedAt != nil && state.PrunedAt == nil) {
+			report.Conflicts = append(report.Conflicts, key)
+			continue
+		}
+		if state.DestroyedAt == nil && (existing.Contents != record.Contents || existing.HMAC != record.HMAC || existing.KPId != record.KPId) {
+			report.Conflicts = append(report.Conflicts, key)
+			continue
+		}
+
+		if sameState(state, secondaryState) {
+			continue
+		}
+		report.StateDiverged = append(report.StateDiverged, key)
+		if repair {
+			if err := ReplayState(ctx, m.secondary, keyPath, state, secondaryState); err != nil {
+				return fmt.Errorf("version %d: %v", version, err)
+			}
+			report.Repaired++
+		}
+	}
+
+	for _, version := range sortedVersions(secondaryRecords) {
+		if _, ok := primaryRecords[version]; !ok {
+			report.SecondaryOnly = append(report.SecondaryOnly, VersionKey{KeyPath: keyPath, Version: version})
+		}
+	}
+
+	return nil
+}
+
+// snapshot reads every version of keyPath and its deletion state, indexed by version.
+func snapshot(ctx context.Context, s Storage, keyPath string) (map[int]Record, map[int]VersionInfo, error) {
+	records, err := s.Export(ctx, keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to export: %v", err)
+	}
+	infos, err := s.Versions(ctx, keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to describe versions: %v", err)
+	}
+
+	recordsByVersion := make(map[int]Record, len(records))
+	for _, record := range records {
+		recordsByVersion[record.Version] = record
+	}
+	states := make(map[int]VersionInfo, len(infos))
+	for _, info := range infos {
+		states[info.Version] = info
+	}
+	return recordsByVersion, states, nil
+}
+
+// sortedVersions returns the versions of records in ascending order.
+func sortedVersions(records map[int]Record) []int {
+	versions := make([]int, 0, len(records))
+	for version := range records {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// sameState reports whether two versions are in the same deletion state. Whether an erased
+// version had also been soft-deleted makes no difference.
+func sameState(a, b VersionInfo) bool {
+	if (a.DestroyedAt != nil) != (b.DestroyedAt != nil) || (a.PrunedAt != nil) != (b.PrunedAt != nil) {
+		return false
+	}
+	return a.DestroyedAt != nil || (a.DeletedAt != nil) == (b.DeletedAt != nil)
+}
+
+// ReplayState brings the deletion state of a version on s from current to want, for copying a
+// version's state from one storage to another. Deletion timestamps are those of the replay, not
+// the original ones.
+func ReplayState(ctx context.Context, s Storage, keyPath string, want, current VersionInfo) error {
+	switch {
+	case want.PrunedAt != nil:
+		if current.PrunedAt == nil {
+			return s.Prune(ctx, keyPath, want.Version)
+		}
+	case want.DestroyedAt != nil:
+		if current.DestroyedAt == nil {
+			return s.Destroy(ctx, keyPath, want.Version)
+		}
+	case want.DeletedAt != nil:
+		if current.DeletedAt == nil {
+			return s.Delete(ctx, keyPath, want.Version)
+		}
+	case current.DeletedAt != nil:
+		return s.Undelete(ctx, keyPath, want.Version)
+	}
+	return nil
+}
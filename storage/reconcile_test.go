@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// keys formats version keys as keyPath@version for comparison.
+func keys(versionKeys []VersionKey) string {
+	formatted := make([]string, len(versionKeys))
+	for i, key := range versionKeys {
+		formatted[i] = fmt.Sprintf("%s@%d", key.KeyPath, key.Version)
+	}
+	return strings.Join(formatted, " ")
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	mirror, primary, secondary := newTestMirror(t)
+
+	// Each key path sets up one way for the secondary to differ from the primary
+	setup := []struct {
+		s      Storage
+		record Record
+		delete bool
+	}{
+		{s: mirror, record: record("app/in-sync", 1, "one")},
+		{s: primary, record: record("app/missing", 1, "one")},
+		{s: primary, record: record("app/missing", 2, "two"), delete: true},
+		{s: mirror, record: record("app/partial", 1, "one")},
+		{s: primary, record: record("app/partial", 2, "two")},
+		{s: secondary, record: record("app/partial", 3, "three")},
+		{s: mirror, record: record("app/deleted", 1, "one")},
+		{s: mirror, record: record("app/undeleted", 1, "one")},
+		{s: mirror, record: record("app/destroyed", 1, "one")},
+		{s: primary, record: record("app/conflict", 1, "one")},
+		{s: secondary, record: record("app/conflict", 1, "other")},
+		{s: mirror, record: record("app/erased", 1, "one")},
+		{s: secondary, record: record("app/extra", 1, "one")},
+	}
+	for _, step := range setup {
+		if err := step.s.Store(ctx, step.record); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+		if step.delete {
+			if err := step.s.Delete(ctx, step.record.KeyPath, step.record.Version); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+		}
+	}
+	for _, err := range []error{
+		primary.Delete(ctx, "app/deleted", 1),
+		secondary.Delete(ctx, "app/undeleted", 1),
+		primary.Destroy(ctx, "app/destroyed", 1),
+		secondary.Destroy(ctx, "app/erased", 1),
+	} {
+		if err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+
+	check := func(report *ReconcileReport, wantMissing, wantDiverged string, wantRepaired int) {
+		t.Helper()
+
+		if report.Keys != 9 {
+			t.Errorf("got %d keys, want 9", report.Keys)
+		}
+		if got := keys(report.Missing); got != wantMissing {
+			t.Errorf("Missing: got %q, want %q", got, wantMissing)
+		}
+		if got := keys(report.StateDiverged); got != wantDiverged {
+			t.Errorf("StateDiverged: got %q, want %q", got, wantDiverged)
+		}
+		if got, want := keys(report.Conflicts), "app/conflict@1 app/erased@1"; got != want {
+			t.Errorf("Conflicts: got %q, want %q", got, want)
+		}
+		if got, want := keys(report.SecondaryOnly), "app/partial@3 app/extra@1"; got != want {
+			t.Errorf("SecondaryOnly: got %q, want %q", got, want)
+		}
+		if report.Repaired != wantRepaired {
+			t.Errorf("Repaired: got %d, want %d", report.Repaired, wantRepaired)
+		}
+	}
+	const (
+		missing  = "app/missing@1 app/missing@2 app/partial@2"
+		diverged = "app/deleted@1 app/destroyed@1 app/undeleted@1"
+	)
+
+	// Comparing leaves the secondary alone, so a second comparison finds the same differences
+	for i := 0; i < 2; i++ {
+		report, err := mirror.Reconcile(ctx, "app/", false)
+		if err != nil {
+			t.Fatalf("Reconcile: %v", err)
+		}
+		check(report, missing, diverged, 0)
+	}
+
+	report, err := mirror.Reconcile(ctx, "app/", true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	check(report, missing, diverged, 6)
+
+	// Only the differences that are never repaired automatically remain
+	report, err = mirror.Reconcile(ctx, "app/", false)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	check(report, "", "", 0)
+
+	retrieved := []struct {
+		keyPath      string
+		version      int
+		wantContents string
+		wantErr      error
+	}{
+		{keyPath: "app/missing", version: 1, wantContents: "one"},
+		{keyPath: "app/missing", version: 2, wantErr: ErrVersionDeleted},
+		{keyPath: "app/partial", version: 2, wantContents: "two"},
+		{keyPath: "app/deleted", version: 1, wantErr: ErrVersionDeleted},
+		{keyPath: "app/undeleted", version: 1, wantContents: "one"},
+		{keyPath: "app/destroyed", version: 1, wantErr: ErrVersionDestroyed},
+		{keyPath: "app/conflict", version: 1, wantContents: "other"},
+	}
+	for _, tt := range retrieved {
+		contents, _, _, err := secondary.Retrieve(ctx, tt.keyPath, tt.version)
+		if !errors.Is(err, tt.wantErr) || contents != tt.wantContents {
+			t.Errorf("secondary Retrieve %s@%d: got %q, %v, want %q, %v", tt.keyPath, tt.version, contents, err, tt.wantContents, tt.wantErr)
+		}
+	}
+}
+
+func TestReconcileSecondaryFailure(t *testing.T) {
+	ctx := context.Background()
+	mirror, primary, secondary := newTestMirror(t)
+
+	if err := primary.Store(ctx, record("app/db", 1, "one")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	report, err := mirror.Reconcile(ctx, "", false)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if got := keys(report.Missing); got != "app/db@1" {
+		t.Errorf("Missing: got %q, want %q", got, "app/db@1")
+	}
+
+	// A repair that cannot write to the secondary stops with the error instead of reporting success
+	secondary.down = true
+	report, err = mirror.Reconcile(ctx, "", true)
+	if err == nil || !strings.Contains(err.Error(), errUnavailable.Error()) {
+		t.Errorf("Reconcile: got error %v, want %v", err, errUnavailable)
+	}
+	if report.Repaired != 0 {
+		t.Errorf("Repaired: got %d, want 0", report.Repaired)
+	}
+}
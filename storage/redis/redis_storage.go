@@ -0,0 +1,579 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+// batchSize bounds the records written by a single script call or looked up by a single pipeline.
+const batchSize = 500
+
+// maxUpdateAttempts bounds how often a deletion state change is retried when the version
+// is modified concurrently.
+const maxUpdateAttempts = 5
+
+// storeScript writes records only if their versions do not exist yet. KEYS[1] is the key path
+// index and record i uses KEYS[2i] (its version set) and KEYS[2i+1] (its record hash);
+// ARGV[1] is "1" for an atomic write and record i uses ARGV[3i-1..3i+1] (key path, version,
+// value). It returns one flag per record, 1 meaning the version already existed. An atomic
+// write stores nothing if any flag is set.
+var storeScript = redis.NewScript(`
+local atomic = ARGV[1] == "1"
+local n = (#KEYS - 1) / 2
+local conflicts = {}
+local seen = {}
+local conflicted = false
+
+for i = 1, n do
+  local records, version = KEYS[2 * i + 1], ARGV[3 * i]
+  local id = records .. "\0" .. version
+  if seen[id] or redis.call("HEXISTS", records, version) == 1 then
+    conflicts[i] = 1
+    conflicted = true
+  else
+    conflicts[i] = 0
+  end
+  seen[id] = true
+end
+
+if atomic and conflicted then
+  return conflicts
+end
+
+for i = 1, n do
+  if conflicts[i] == 0 then
+    local version = ARGV[3 * i]
+    redis.call("HSET", KEYS[2 * i + 1], version, ARGV[3 * i + 1])
+    redis.call("ZADD", KEYS[2 * i], version, version)
+    redis.call("ZADD", KEYS[1], 0, ARGV[3 * i - 1])
+  end
+end
+return conflicts
+`)
+
+// RedisStorage implements the Storage interface on Redis. Each key path has a hash of its
+// records keyed by version and a sorted set of its versions scored by version number, and a
+// sorted set of every key path with equal scores serves lexical listing. New versions are
+// written by a Lua script so that the existence check and the write are atomic.
+type RedisStorage struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+// kvRecord is the value stored for a single version.
+type kvRecord struct {
+	Contents string `json:"contents"`
+	HMAC     string `json:"hmac"`
+	KPID     string `json:"kp_id"`
+
+	CreatedAt   time.Time `json:"created_at"`
+	Encryptor   string    `json:"encryptor,omitempty"`
+	KeyProvider string    `json:"key_provider,omitempty"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	DestroyedAt *time.Time `json:"destroyed_at,omitempty"`
+	PrunedAt    *time.Time `json:"pruned_at,omitempty"`
+}
+
+// NewRedisStorage creates a new instance of RedisStorage from a redis:// or rediss:// URL.
+// Every Redis key it uses starts with keyPrefix.
+func NewRedisStorage(connectionString, keyPrefix string) (*RedisStorage, error) {
+	opts, err := redis.ParseURL(connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection string: %v", err)
+	}
+
+	return &RedisStorage{
+		client:    redis.NewClient(opts),
+		keyPrefix: keyPrefix,
+	}, nil
+}
+
+// Migrate checks that Redis is reachable. Redis needs no schema.
+func (r *RedisStorage) Migrate(ctx context.Context) error {
+	return r.Ping(ctx)
+}
+
+// Ping checks that Redis is reachable.
+func (r *RedisStorage) Ping(ctx context.Context) error {
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping redis: %v", err)
+	}
+	return nil
+}
+
+// Store stores the record with its metadata.
+func (r *RedisStorage) Store(ctx context.Context, record common.Record) error {
+	conflicts, err := r.store(ctx, []common.Record{record}, false)
+	if err != nil {
+		return err
+	}
+	if conflicts[0] {
+		return common.ErrVersionConflict
+	}
+	return nil
+}
+
+// BatchStore stores the records with one script call per chunk.
+func (r *RedisStorage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	errs := make([]error, len(records))
+
+	for start := 0; start < len(records); start += batchSize {
+		chunk := records[start:min(start+batchSize, len(records))]
+
+		conflicts, err := r.store(ctx, chunk, false)
+		if err != nil {
+			return nil, err
+		}
+		for i, conflict := range conflicts {
+			if conflict {
+				errs[start+i] = common.ErrVersionConflict
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// StoreAtomic stores the records with a single script call that writes nothing if any version already exists.
+func (r *RedisStorage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	conflicts, err := r.store(ctx, records, true)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		if conflict {
+			return common.ErrVersionConflict
+		}
+	}
+	return nil
+}
+
+// store runs storeScript for the records and reports which versions already existed.
+func (r *RedisStorage) store(ctx context.Context, records []common.Record, atomic bool) ([]bool, error) {
+	keys := make([]string, 0, 1+2*len(records))
+	args := make([]interface{}, 0, 1+3*len(records))
+
+	keys = append(keys, r.indexKey())
+	if atomic {
+		args = append(args, "1")
+	} else {
+		args = append(args, "0")
+	}
+
+	for _, record := range records {
+		value, err := json.Marshal(kvRecord{
+			Contents:    record.Contents,
+			HMAC:        record.HMAC,
+			KPID:        record.KPId,
+			CreatedAt:   record.Metadata.CreatedAt.UTC(),
+			Encryptor:   record.Metadata.Encryptor,
+			KeyProvider: record.Metadata.KeyProvider,
+			CreatedBy:   record.Metadata.CreatedBy,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, r.versionsKey(record.KeyPath), r.recordsKey(record.KeyPath))
+		args = append(args, record.KeyPath, record.Version, value)
+	}
+
+	flags, err := storeScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert items: %v", err)
+	}
+
+	conflicts := make([]bool, len(flags))
+	for i, flag := range flags {
+		conflicts[i] = flag == 1
+	}
+	return conflicts, nil
+}
+
+// Retrieve retrieves the value for the specified key and version.
+func (r *RedisStorage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	value, err := r.client.HGet(ctx, r.recordsKey(keyPath), strconv.Itoa(version)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", "", "", nil
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to retrieve item: %v", err)
+	}
+
+	var record kvRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return "", "", "", fmt.Errorf("failed to retrieve item: %v", err)
+	}
+	if err := common.StateError(record.DeletedAt, record.DestroyedAt); err != nil {
+		return "", "", "", err
+	}
+
+	return record.Contents, record.HMAC, record.KPID, nil
+}
+
+// LatestVersion returns the latest version of the value for the specified key.
+func (r *RedisStorage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	return latestVersion(r.client.ZRevRangeWithScores(ctx, r.versionsKey(keyPath), 0, 0))
+}
+
+// BatchRetrieve retrieves the versions with one pipeline per chunk.
+func (r *RedisStorage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	results := make([]common.RetrieveResult, len(keys))
+
+	for start := 0; start < len(keys); start += batchSize {
+		chunk := keys[start:min(start+batchSize, len(keys))]
+
+		cmds := make([]*redis.StringCmd, len(chunk))
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range chunk {
+				cmds[i] = pipe.HGet(ctx, r.recordsKey(key.KeyPath), strconv.Itoa(key.Version))
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("failed to retrieve items: %v", err)
+		}
+
+		for i, cmd := range cmds {
+			value, err := cmd.Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+
+			var record kvRecord
+			if err == nil {
+				err = json.Unmarshal([]byte(value), &record)
+			}
+			if err != nil {
+				results[start+i].Err = fmt.Errorf("failed to retrieve item: %v", err)
+				continue
+			}
+			if err := common.StateError(record.DeletedAt, record.DestroyedAt); err != nil {
+				results[start+i].Err = err
+				continue
+			}
+			results[start+i] = common.RetrieveResult{Contents: record.Contents, HMAC: record.HMAC, KPId: record.KPID}
+		}
+	}
+
+	return results, nil
+}
+
+// BatchLatestVersion returns the latest version of each key path with one pipeline per chunk.
+func (r *RedisStorage) BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	latest := make(map[string]int, len(keyPaths))
+
+	for start := 0; start < len(keyPaths); start += batchSize {
+		chunk := keyPaths[start:min(start+batchSize, len(keyPaths))]
+
+		versions, err := r.latestVersions(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		for i, version := range versions {
+			if version != 0 {
+				latest[chunk[i]] = version
+			}
+		}
+	}
+
+	return latest, nil
+}
+
+// latestVersions looks up the latest version of each key path in a single pipeline.
+func (r *RedisStorage) latestVersions(ctx context.Context, keyPaths []string) ([]int, error) {
+	cmds := make([]*redis.ZSliceCmd, len(keyPaths))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, keyPath := range keyPaths {
+			cmds[i] = pipe.ZRevRangeWithScores(ctx, r.versionsKey(keyPath), 0, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read latest versions: %v", err)
+	}
+
+	versions := make([]int, len(keyPaths))
+	for i, cmd := range cmds {
+		if versions[i], err = latestVersion(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// Versions describes every stored version of the key path in ascending order.
+func (r *RedisStorage) Versions(ctx context.Context, keyPath string) ([]common.VersionInfo, error) {
+	records, err := r.records(ctx, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]common.VersionInfo, len(records))
+	for i, record := range records {
+		versions[i] = record.value.versionInfo(record.version)
+	}
+	return versions, nil
+}
+
+// Export returns every stored version of the key path in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (r *RedisStorage) Export(ctx context.Context, keyPath string) ([]common.Record, error) {
+	records, err := r.records(ctx, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make([]common.Record, len(records))
+	for i, record := range records {
+		exported[i] = common.Record{
+			KeyPath:  keyPath,
+			Version:  record.version,
+			Contents: record.value.Contents,
+			HMAC:     record.value.HMAC,
+			KPId:     record.value.KPID,
+			Metadata: record.value.versionInfo(record.version).Metadata,
+		}
+	}
+	return exported, nil
+}
+
+// versionedRecord is a stored record together with its version.
+type versionedRecord struct {
+	version int
+	value   kvRecord
+}
+
+// records reads every stored version of the key path in ascending order.
+func (r *RedisStorage) records(ctx context.Context, keyPath string) ([]versionedRecord, error) {
+	values, err := r.client.HGetAll(ctx, r.recordsKey(keyPath)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions: %v", err)
+	}
+
+	records := make([]versionedRecord, 0, len(values))
+	for field, value := range values {
+		version, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read versions: invalid version %q", field)
+		}
+
+		var record kvRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, fmt.Errorf("failed to read versions: %v", err)
+		}
+		records = append(records, versionedRecord{version: version, value: record})
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].version < records[j].version })
+	return records, nil
+}
+
+// List returns key paths starting with prefix in key path order, with their latest versions.
+func (r *RedisStorage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	after, err := common.DecodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 0xff never occurs in UTF-8, so it sorts after every key path starting with prefix
+	start := "[" + prefix
+	if after >= prefix {
+		start = "(" + after
+	}
+
+	keyPaths, err := r.client.ZRangeByLex(ctx, r.indexKey(), &redis.ZRangeBy{
+		Min:   start,
+		Max:   "[" + prefix + "\xff",
+		Count: int64(limit + 1),
+	}).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list keys: %v", err)
+	}
+
+	versions, err := r.latestVersions(ctx, keyPaths)
+	if err != nil {
+		return nil, "", err
+	}
+
+	summaries := make([]common.KeySummary, len(keyPaths))
+	for i, keyPath := range keyPaths {
+		summaries[i] = common.KeySummary{KeyPath: keyPath, LatestVersion: versions[i]}
+	}
+
+	summaries, next := common.Page(summaries, limit)
+	return summaries, next, nil
+}
+
+// Delete soft-deletes the specified version.
+func (r *RedisStorage) Delete(ctx context.Context, keyPath string, version int) error {
+	return r.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if record.DeletedAt == nil {
+			now := time.Now().UTC()
+			record.DeletedAt = &now
+		}
+		return nil
+	})
+}
+
+// Undelete recovers a soft-deleted version.
+func (r *RedisStorage) Undelete(ctx context.Context, keyPath string, version int) error {
+	return r.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		record.DeletedAt = nil
+		return nil
+	})
+}
+
+// Destroy permanently erases the contents of the specified version.
+func (r *RedisStorage) Destroy(ctx context.Context, keyPath string, version int) error {
+	return r.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt == nil {
+			now := time.Now().UTC()
+			record.DestroyedAt = &now
+		}
+		record.Contents = ""
+		record.HMAC = ""
+		record.KPID = ""
+		return nil
+	})
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (r *RedisStorage) Prune(ctx context.Context, keyPath string, version int) error {
+	return r.update(ctx, keyPath, version, func(record *kvRecord) error {
+		now := time.Now().UTC()
+		if record.DestroyedAt == nil {
+			record.DestroyedAt = &now
+		}
+		if record.PrunedAt == nil {
+			record.PrunedAt = &now
+		}
+		record.Contents = ""
+		record.HMAC = ""
+		record.KPID = ""
+		return nil
+	})
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (r *RedisStorage) UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error {
+	return r.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if record.KPID == newKPId {
+			return nil
+		}
+		if record.KPID != oldKPId {
+			return common.ErrVersionConflict
+		}
+		record.KPID = newKPId
+		return nil
+	})
+}
+
+// update applies fn to a stored version in a MULTI transaction that is retried if the
+// record hash changes between reading and writing it.
+func (r *RedisStorage) update(ctx context.Context, keyPath string, version int, fn func(record *kvRecord) error) error {
+	recordsKey, field := r.recordsKey(keyPath), strconv.Itoa(version)
+
+	txf := func(tx *redis.Tx) error {
+		value, err := tx.HGet(ctx, recordsKey, field).Result()
+		if errors.Is(err, redis.Nil) {
+			return common.ErrVersionNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var record kvRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return err
+		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+
+		updated, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, recordsKey, field, updated)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := r.client.Watch(ctx, txf, recordsKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update %s version %d: modified concurrently", keyPath, version)
+}
+
+// indexKey is the sorted set of every stored key path.
+func (r *RedisStorage) indexKey() string {
+	return r.keyPrefix + "keys"
+}
+
+// versionsKey is the sorted set of the stored versions of a key path.
+func (r *RedisStorage) versionsKey(keyPath string) string {
+	return r.keyPrefix + "versions:" + keyPath
+}
+
+// recordsKey is the hash of the stored records of a key path, keyed by version.
+func (r *RedisStorage) recordsKey(keyPath string) string {
+	return r.keyPrefix + "records:" + keyPath
+}
+
+// latestVersion reads the result of a ZREVRANGE 0 0 WITHSCORES on a version set.
+func latestVersion(cmd *redis.ZSliceCmd) (int, error) {
+	members, err := cmd.Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read latest version: %v", err)
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+	return int(members[0].Score), nil
+}
+
+func (r kvRecord) versionInfo(version int) common.VersionInfo {
+	return common.VersionInfo{
+		Version: version,
+		Metadata: common.Metadata{
+			CreatedAt:   r.CreatedAt,
+			Encryptor:   r.Encryptor,
+			KeyProvider: r.KeyProvider,
+			CreatedBy:   r.CreatedBy,
+		},
+		DeletedAt:   r.DeletedAt,
+		DestroyedAt: r.DestroyedAt,
+		PrunedAt:    r.PrunedAt,
+	}
+}
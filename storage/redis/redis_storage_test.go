@@ -0,0 +1,347 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+// newTestStorage returns a RedisStorage on a fresh in-memory Redis server.
+func newTestStorage(t *testing.T) *RedisStorage {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	r, err := NewRedisStorage("redis://"+server.Addr(), "owlvault:")
+	if err != nil {
+		t.Fatalf("NewRedisStorage: %v", err)
+	}
+	t.Cleanup(func() { r.client.Close() })
+	return r
+}
+
+func record(keyPath string, version int, contents string) common.Record {
+	return common.Record{KeyPath: keyPath, Version: version, Contents: contents, HMAC: "hmac", KPId: "kp"}
+}
+
+func TestBatchStoreConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  []common.Record
+		records []common.Record
+		want    []error
+	}{
+		{
+			name:    "new versions",
+			records: []common.Record{record("a", 1, "a1"), record("a", 2, "a2"), record("b", 1, "b1")},
+			want:    []error{nil, nil, nil},
+		},
+		{
+			name:    "existing version",
+			stored:  []common.Record{record("a", 1, "old")},
+			records: []common.Record{record("a", 1, "a1"), record("a", 2, "a2")},
+			want:    []error{common.ErrVersionConflict, nil},
+		},
+		{
+			name:    "duplicate version within the batch",
+			records: []common.Record{record("a", 1, "first"), record("a", 1, "second"), record("b", 1, "b1")},
+			want:    []error{nil, common.ErrVersionConflict, nil},
+		},
+		{
+			name:    "same version of different key paths",
+			records: []common.Record{record("a", 1, "a1"), record("a/b", 1, "ab1")},
+			want:    []error{nil, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newTestStorage(t)
+			for _, stored := range tt.stored {
+				if err := r.Store(ctx, stored); err != nil {
+					t.Fatalf("Store: %v", err)
+				}
+			}
+
+			errs, err := r.BatchStore(ctx, tt.records)
+			if err != nil {
+				t.Fatalf("BatchStore: %v", err)
+			}
+			for i, want := range tt.want {
+				if !errors.Is(errs[i], want) {
+					t.Errorf("record %d: got error %v, want %v", i, errs[i], want)
+				}
+			}
+
+			// A conflicting record must leave the version that was there first untouched
+			wantContents := map[string]string{}
+			for _, stored := range tt.stored {
+				wantContents[stored.KeyPath+"@"+strconv.Itoa(stored.Version)] = stored.Contents
+			}
+			for i, record := range tt.records {
+				id := record.KeyPath + "@" + strconv.Itoa(record.Version)
+				if _, ok := wantContents[id]; !ok && errs[i] == nil {
+					wantContents[id] = record.Contents
+				}
+			}
+			for id, want := range wantContents {
+				keyPath, version, _ := strings.Cut(id, "@")
+				v, _ := strconv.Atoi(version)
+				contents, _, _, err := r.Retrieve(ctx, keyPath, v)
+				if err != nil || contents != want {
+					t.Errorf("Retrieve(%s): got %q, %v, want %q", id, contents, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreAtomic(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  []common.Record
+		records []common.Record
+		wantErr error
+	}{
+		{
+			name:    "all new",
+			records: []common.Record{record("a", 1, "a1"), record("b", 1, "b1")},
+		},
+		{
+			name:    "one existing version",
+			stored:  []common.Record{record("b", 1, "old")},
+			records: []common.Record{record("a", 1, "a1"), record("b", 1, "b1")},
+			wantErr: common.ErrVersionConflict,
+		},
+		{
+			name:    "duplicate version within the batch",
+			records: []common.Record{record("a", 1, "first"), record("b", 1, "b1"), record("a", 1, "second")},
+			wantErr: common.ErrVersionConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newTestStorage(t)
+			for _, stored := range tt.stored {
+				if err := r.Store(ctx, stored); err != nil {
+					t.Fatalf("Store: %v", err)
+				}
+			}
+
+			err := r.StoreAtomic(ctx, tt.records)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("StoreAtomic: got error %v, want %v", err, tt.wantErr)
+			}
+
+			// Either every record is stored or none of them is
+			for _, record := range tt.records {
+				contents, _, _, err := r.Retrieve(ctx, record.KeyPath, record.Version)
+				if err != nil {
+					t.Fatalf("Retrieve: %v", err)
+				}
+				stored := ""
+				for _, s := range tt.stored {
+					if s.KeyPath == record.KeyPath && s.Version == record.Version {
+						stored = s.Contents
+					}
+				}
+				want := stored
+				if tt.wantErr == nil {
+					want = record.Contents
+				}
+				if contents != want {
+					t.Errorf("Retrieve(%s, %d): got %q, want %q", record.KeyPath, record.Version, contents, want)
+				}
+			}
+
+			summaries, _, err := r.List(ctx, "", 10, "")
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if tt.wantErr != nil && len(summaries) != len(tt.stored) {
+				t.Errorf("List: got %v after a failed atomic batch, want only the stored keys", summaries)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	r := newTestStorage(t)
+
+	// Stored out of order, with several versions and prefixes sharing a key path
+	for _, record := range []common.Record{
+		record("b", 1, ""), record("a/c", 1, ""), record("a", 1, ""), record("a", 2, ""),
+		record("a/b", 1, ""), record("a-b", 1, ""), record("a/b", 3, ""), record("c", 1, ""),
+	} {
+		if err := r.Store(ctx, record); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{prefix: "", limit: 100, want: []string{"a@2", "a-b@1", "a/b@3", "a/c@1", "b@1", "c@1"}},
+		{prefix: "", limit: 1, want: []string{"a@2", "a-b@1", "a/b@3", "a/c@1", "b@1", "c@1"}},
+		{prefix: "", limit: 4, want: []string{"a@2", "a-b@1", "a/b@3", "a/c@1", "b@1", "c@1"}},
+		{prefix: "a", limit: 2, want: []string{"a@2", "a-b@1", "a/b@3", "a/c@1"}},
+		{prefix: "a/", limit: 1, want: []string{"a/b@3", "a/c@1"}},
+		{prefix: "d", limit: 1, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix+"/"+strconv.Itoa(tt.limit), func(t *testing.T) {
+			var got []string
+			token := ""
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatalf("List did not finish after %d pages", pages)
+				}
+
+				summaries, next, err := r.List(ctx, tt.prefix, tt.limit, token)
+				if err != nil {
+					t.Fatalf("List: %v", err)
+				}
+				if len(summaries) > tt.limit {
+					t.Fatalf("List: got %d summaries, want at most %d", len(summaries), tt.limit)
+				}
+				for _, summary := range summaries {
+					got = append(got, summary.KeyPath+"@"+strconv.Itoa(summary.LatestVersion))
+				}
+				if next == "" {
+					break
+				}
+				token = next
+			}
+
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("List: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateRetriesConcurrentModification(t *testing.T) {
+	tests := []struct {
+		name          string
+		modifications int
+		wantErr       bool
+	}{
+		{name: "no modification", modifications: 0},
+		{name: "modified once", modifications: 1},
+		{name: "modified on every attempt", modifications: maxUpdateAttempts, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newTestStorage(t)
+			if err := r.Store(ctx, record("a", 1, "a1")); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			if err := r.Store(ctx, record("a", 2, "a2")); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+
+			// Changing another version of the same hash between the read and the write
+			// invalidates the WATCH, so the transaction has to run again
+			attempts := 0
+			err := r.update(ctx, "a", 1, func(record *kvRecord) error {
+				attempts++
+				if attempts <= tt.modifications {
+					if err := r.Delete(ctx, "a", 2); err != nil {
+						return err
+					}
+					if err := r.Undelete(ctx, "a", 2); err != nil {
+						return err
+					}
+				}
+				record.KPID = "new-kp"
+				return nil
+			})
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("update: succeeded although every attempt was modified concurrently")
+				}
+				if attempts != maxUpdateAttempts {
+					t.Errorf("update: got %d attempts, want %d", attempts, maxUpdateAttempts)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("update: %v", err)
+				}
+				if attempts != tt.modifications+1 {
+					t.Errorf("update: got %d attempts, want %d", attempts, tt.modifications+1)
+				}
+			}
+
+			_, _, kpID, err := r.Retrieve(ctx, "a", 1)
+			if err != nil {
+				t.Fatalf("Retrieve: %v", err)
+			}
+			wantKPId := "new-kp"
+			if tt.wantErr {
+				wantKPId = "kp"
+			}
+			if kpID != wantKPId {
+				t.Errorf("Retrieve: got kp_id %q, want %q", kpID, wantKPId)
+			}
+		})
+	}
+}
+
+func TestUpdateKPId(t *testing.T) {
+	tests := []struct {
+		name      string
+		destroyed bool
+		version   int
+		oldKPId   string
+		newKPId   string
+		wantErr   error
+		wantKPId  string
+	}{
+		{name: "replaces the old blob", version: 1, oldKPId: "kp", newKPId: "new", wantKPId: "new"},
+		{name: "already replaced", version: 1, oldKPId: "other", newKPId: "kp", wantKPId: "kp"},
+		{name: "changed in the meantime", version: 1, oldKPId: "other", newKPId: "new", wantErr: common.ErrVersionConflict, wantKPId: "kp"},
+		{name: "destroyed", destroyed: true, version: 1, oldKPId: "kp", newKPId: "new", wantErr: common.ErrVersionDestroyed},
+		{name: "missing version", version: 2, oldKPId: "kp", newKPId: "new", wantErr: common.ErrVersionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newTestStorage(t)
+			if err := r.Store(ctx, record("a", 1, "a1")); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			if tt.destroyed {
+				if err := r.Destroy(ctx, "a", 1); err != nil {
+					t.Fatalf("Destroy: %v", err)
+				}
+			}
+
+			err := r.UpdateKPId(ctx, "a", tt.version, tt.oldKPId, tt.newKPId)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateKPId: got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantKPId == "" {
+				return
+			}
+			if _, _, kpID, _ := r.Retrieve(ctx, "a", 1); kpID != tt.wantKPId {
+				t.Errorf("Retrieve: got kp_id %q, want %q", kpID, tt.wantKPId)
+			}
+		})
+	}
+}
@@ -0,0 +1,697 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/ngoyal16/owlvault/storage/common"
+)
+
+// versionDigits is the width version numbers are zero-padded to in object keys, so that
+// the object keys of a key path sort in version order.
+const versionDigits = 10
+
+// maxVersion is the largest version that fits in versionDigits.
+const maxVersion = 9999999999
+
+// batchConcurrency bounds the concurrent requests issued for operations S3 cannot batch.
+const batchConcurrency = 16
+
+// maxUpdateAttempts bounds how often a deletion state change is retried when the object
+// is modified concurrently.
+const maxUpdateAttempts = 5
+
+// S3Storage implements the Storage interface on an S3-compatible bucket. Each version is an
+// object named <prefix>/<key_path>/<version>, written with conditional requests so that a
+// version is never overwritten: new versions use If-None-Match and deletion state changes
+// use If-Match on the ETag they read.
+type S3Storage struct {
+	svc *s3.S3
+
+	bucket string
+	root   string
+}
+
+// settings holds the optional configuration of an S3Storage.
+type settings struct {
+	endpoint  string
+	pathStyle bool
+}
+
+// Option represents an option for configuring a new S3Storage.
+type Option func(*settings)
+
+// WithEndpoint sends requests to a custom endpoint, such as MinIO, instead of the regional one.
+func WithEndpoint(endpoint string) Option {
+	return func(s *settings) {
+		s.endpoint = endpoint
+	}
+}
+
+// WithPathStyle addresses the bucket in the URL path instead of the host name, as most
+// S3-compatible servers require.
+func WithPathStyle(enabled bool) Option {
+	return func(s *settings) {
+		s.pathStyle = enabled
+	}
+}
+
+// kvRecord is the body of the object stored for a single version.
+type kvRecord struct {
+	Contents string `json:"contents"`
+	HMAC     string `json:"hmac"`
+	KPID     string `json:"kp_id"`
+
+	CreatedAt   time.Time `json:"created_at"`
+	Encryptor   string    `json:"encryptor,omitempty"`
+	KeyProvider string    `json:"key_provider,omitempty"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	DestroyedAt *time.Time `json:"destroyed_at,omitempty"`
+	PrunedAt    *time.Time `json:"pruned_at,omitempty"`
+}
+
+// NewS3Storage creates a new instance of S3Storage storing objects in bucket under prefix.
+func NewS3Storage(region, bucket, prefix string, opts ...Option) (*S3Storage, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("a bucket is required")
+	}
+
+	var s settings
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	awsConfig := &aws.Config{
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(s.pathStyle),
+	}
+	if s.endpoint != "" {
+		awsConfig.Endpoint = aws.String(s.endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	root := strings.Trim(prefix, "/")
+	if root != "" {
+		root += "/"
+	}
+
+	return &S3Storage{
+		svc:    s3.New(sess),
+		bucket: bucket,
+		root:   root,
+	}, nil
+}
+
+// Migrate creates the bucket if it does not exist yet.
+func (s *S3Storage) Migrate(ctx context.Context) error {
+	_, err := s.svc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	if err == nil {
+		return nil
+	}
+	if statusCode(err) != http.StatusNotFound {
+		return fmt.Errorf("failed to access bucket %s: %v", s.bucket, err)
+	}
+
+	input := &s3.CreateBucketInput{Bucket: aws.String(s.bucket)}
+	if region := aws.StringValue(s.svc.Config.Region); region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(region)}
+	}
+	if _, err := s.svc.CreateBucketWithContext(ctx, input); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %v", s.bucket, err)
+	}
+	return nil
+}
+
+// Ping checks that the bucket exists and is accessible.
+func (s *S3Storage) Ping(ctx context.Context) error {
+	if _, err := s.svc.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)}); err != nil {
+		return fmt.Errorf("failed to access bucket %s: %v", s.bucket, err)
+	}
+	return nil
+}
+
+// Store stores the record with its metadata.
+func (s *S3Storage) Store(ctx context.Context, record common.Record) error {
+	if record.Version < 1 || record.Version > maxVersion {
+		return fmt.Errorf("version %d is out of range", record.Version)
+	}
+
+	body, err := json.Marshal(kvRecord{
+		Contents:    record.Contents,
+		HMAC:        record.HMAC,
+		KPID:        record.KPId,
+		CreatedAt:   record.Metadata.CreatedAt.UTC(),
+		Encryptor:   record.Metadata.Encryptor,
+		KeyProvider: record.Metadata.KeyProvider,
+		CreatedBy:   record.Metadata.CreatedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.put(ctx, s.objectKey(record.KeyPath, record.Version), body, "If-None-Match", "*")
+	if isPreconditionFailed(err) {
+		return common.ErrVersionConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put object: %v", err)
+	}
+	return nil
+}
+
+// BatchStore stores the records with parallel conditional puts, as S3 has no batch write.
+func (s *S3Storage) BatchStore(ctx context.Context, records []common.Record) ([]error, error) {
+	errs := make([]error, len(records))
+	parallel(len(records), func(i int) {
+		errs[i] = s.Store(ctx, records[i])
+	})
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, common.ErrVersionConflict) {
+			return nil, err
+		}
+	}
+	return errs, nil
+}
+
+// StoreAtomic stores the records one at a time and deletes the ones already written if a
+// version already exists. S3 has no transactions, so readers may briefly see part of the
+// batch and a crash part way through leaves it partly written.
+func (s *S3Storage) StoreAtomic(ctx context.Context, records []common.Record) error {
+	for i, record := range records {
+		err := s.Store(ctx, record)
+		if err == nil {
+			continue
+		}
+
+		for _, written := range records[:i] {
+			_, rollbackErr := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(s.bucket),
+				Key:    aws.String(s.objectKey(written.KeyPath, written.Version)),
+			})
+			if rollbackErr != nil {
+				return fmt.Errorf("%v; failed to roll back %s version %d: %v", err, written.KeyPath, written.Version, rollbackErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Retrieve retrieves the value for the specified key and version.
+func (s *S3Storage) Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	record, _, err := s.get(ctx, s.objectKey(keyPath, version))
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to retrieve item: %v", err)
+	}
+	if record == nil {
+		return "", "", "", nil
+	}
+	if err := common.StateError(record.DeletedAt, record.DestroyedAt); err != nil {
+		return "", "", "", err
+	}
+
+	return record.Contents, record.HMAC, record.KPID, nil
+}
+
+// LatestVersion returns the latest version of the value for the specified key.
+func (s *S3Storage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	versions, err := s.versions(ctx, keyPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, nil
+	}
+	return versions[len(versions)-1], nil
+}
+
+// BatchRetrieve retrieves the versions with parallel requests, as S3 has no batch read.
+func (s *S3Storage) BatchRetrieve(ctx context.Context, keys []common.VersionKey) ([]common.RetrieveResult, error) {
+	results := make([]common.RetrieveResult, len(keys))
+	parallel(len(keys), func(i int) {
+		var result common.RetrieveResult
+		result.Contents, result.HMAC, result.KPId, result.Err = s.Retrieve(ctx, keys[i].KeyPath, keys[i].Version)
+		results[i] = result
+	})
+	return results, nil
+}
+
+// BatchLatestVersion returns the latest version of each key path with parallel listings.
+func (s *S3Storage) BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	versions := make([]int, len(keyPaths))
+	errs := make([]error, len(keyPaths))
+	parallel(len(keyPaths), func(i int) {
+		versions[i], errs[i] = s.LatestVersion(ctx, keyPaths[i])
+	})
+
+	latest := make(map[string]int, len(keyPaths))
+	for i, keyPath := range keyPaths {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if versions[i] != 0 {
+			latest[keyPath] = versions[i]
+		}
+	}
+	return latest, nil
+}
+
+// Versions describes every stored version of the key path in ascending order.
+func (s *S3Storage) Versions(ctx context.Context, keyPath string) ([]common.VersionInfo, error) {
+	versions, records, err := s.records(ctx, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]common.VersionInfo, len(versions))
+	for i, version := range versions {
+		infos[i] = records[i].versionInfo(version)
+	}
+	return infos, nil
+}
+
+// Export returns every stored version of the key path in ascending order, including contents.
+// Deleted versions keep their contents; destroyed versions have none.
+func (s *S3Storage) Export(ctx context.Context, keyPath string) ([]common.Record, error) {
+	versions, records, err := s.records(ctx, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make([]common.Record, len(versions))
+	for i, version := range versions {
+		exported[i] = common.Record{
+			KeyPath:  keyPath,
+			Version:  version,
+			Contents: records[i].Contents,
+			HMAC:     records[i].HMAC,
+			KPId:     records[i].KPID,
+			Metadata: records[i].versionInfo(version).Metadata,
+		}
+	}
+	return exported, nil
+}
+
+// records reads every stored version of the key path in ascending order with parallel requests.
+func (s *S3Storage) records(ctx context.Context, keyPath string) ([]int, []kvRecord, error) {
+	versions, err := s.versions(ctx, keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([]kvRecord, len(versions))
+	errs := make([]error, len(versions))
+	parallel(len(versions), func(i int) {
+		record, _, err := s.get(ctx, s.objectKey(keyPath, versions[i]))
+		if err == nil && record == nil {
+			err = fmt.Errorf("version %d disappeared while being read", versions[i])
+		}
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		records[i] = *record
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read versions: %v", err)
+		}
+	}
+	return versions, records, nil
+}
+
+// versions lists the stored versions of the key path in ascending order.
+func (s *S3Storage) versions(ctx context.Context, keyPath string) ([]int, error) {
+	versions, _, err := s.listDir(ctx, keyPath+"/")
+	return versions, err
+}
+
+// listDir lists the versions stored directly in dir, a key path followed by "/", in
+// ascending order, and reports whether there are key paths below it.
+func (s *S3Storage) listDir(ctx context.Context, dir string) ([]int, bool, error) {
+	prefix := s.root + dir
+
+	var versions []int
+	var hasChildren bool
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if version, ok := parseVersion(strings.TrimPrefix(aws.StringValue(object.Key), prefix)); ok {
+				versions = append(versions, version)
+			}
+		}
+		hasChildren = hasChildren || len(page.CommonPrefixes) > 0
+		return true
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list versions: %v", err)
+	}
+
+	sort.Ints(versions)
+	return versions, hasChildren, nil
+}
+
+// List returns key paths starting with prefix, with their latest versions. The versions of a
+// key path share a "directory" with the key paths nested below it, so the bucket is walked a
+// directory at a time rather than in object key order. Key paths are ordered as if followed
+// by "/", which differs from lexical order when a key path contains characters sorting
+// before "/", and the token is the last key path returned.
+func (s *S3Storage) List(ctx context.Context, prefix string, limit int, token string) ([]common.KeySummary, string, error) {
+	after, err := common.DecodeToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Only the directory holding the prefix is listed, filtered by the rest of the prefix
+	dir, partial := "", prefix
+	if slash := strings.LastIndex(prefix, "/"); slash >= 0 {
+		dir, partial = prefix[:slash+1], prefix[slash+1:]
+	}
+
+	l := &keyLister{s: s, limit: limit, after: after}
+	if err := l.visitChildren(ctx, dir, partial); err != nil {
+		return nil, "", err
+	}
+
+	// The extra summary only shows that there is another page
+	if len(l.summaries) <= limit {
+		return l.summaries, "", nil
+	}
+	summaries := l.summaries[:limit]
+	return summaries, common.EncodeToken(summaries[limit-1].KeyPath), nil
+}
+
+// keyLister walks the directories of a bucket for List. Walking the directories depth first,
+// in the order S3 lists them, visits key paths in the order of their key path followed by "/".
+type keyLister struct {
+	s     *S3Storage
+	limit int
+	// after is the last key path of the previous page, if any.
+	after     string
+	summaries []common.KeySummary
+}
+
+// full reports whether one summary more than the limit has been found.
+func (l *keyLister) full() bool {
+	return len(l.summaries) > l.limit
+}
+
+// visit lists the key path of dir if it has versions, and then the key paths below it.
+func (l *keyLister) visit(ctx context.Context, dir string) error {
+	// The key paths of earlier pages, and the directories holding them, sort up to after
+	if l.after != "" && dir <= l.after+"/" {
+		return l.visitChildren(ctx, dir, "")
+	}
+
+	versions, hasChildren, err := l.s.listDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		l.summaries = append(l.summaries, common.KeySummary{
+			KeyPath:       strings.TrimSuffix(dir, "/"),
+			LatestVersion: versions[len(versions)-1],
+		})
+	}
+	if !hasChildren || l.full() {
+		return nil
+	}
+	return l.visitChildren(ctx, dir, "")
+}
+
+// visitChildren visits the directories in dir whose names start with partial, in order.
+func (l *keyLister) visitChildren(ctx context.Context, dir, partial string) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(l.s.bucket),
+		Prefix:    aws.String(l.s.root + dir + partial),
+		Delimiter: aws.String("/"),
+	}
+
+	// When resuming inside dir, skip the directories before the one holding after
+	var first string
+	if rest, ok := strings.CutPrefix(l.after+"/", dir); l.after != "" && ok && rest != "" {
+		first = dir + rest[:strings.Index(rest, "/")+1]
+		input.StartAfter = aws.String(l.s.root + strings.TrimSuffix(first, "/"))
+	}
+
+	var visitErr error
+	err := l.s.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, commonPrefix := range page.CommonPrefixes {
+			child := strings.TrimPrefix(aws.StringValue(commonPrefix.Prefix), l.s.root)
+			// StartAfter also lets through names extending first's with characters sorting before "/"
+			if child < first {
+				continue
+			}
+			if visitErr = l.visit(ctx, child); visitErr != nil || l.full() {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %v", err)
+	}
+	return visitErr
+}
+
+// Delete soft-deletes the specified version.
+func (s *S3Storage) Delete(ctx context.Context, keyPath string, version int) error {
+	return s.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if record.DeletedAt == nil {
+			now := time.Now().UTC()
+			record.DeletedAt = &now
+		}
+		return nil
+	})
+}
+
+// Undelete recovers a soft-deleted version.
+func (s *S3Storage) Undelete(ctx context.Context, keyPath string, version int) error {
+	return s.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		record.DeletedAt = nil
+		return nil
+	})
+}
+
+// Destroy permanently erases the contents of the specified version. On a bucket with
+// versioning enabled the previous object versions still hold the contents.
+func (s *S3Storage) Destroy(ctx context.Context, keyPath string, version int) error {
+	return s.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt == nil {
+			now := time.Now().UTC()
+			record.DestroyedAt = &now
+		}
+		record.Contents = ""
+		record.HMAC = ""
+		record.KPID = ""
+		return nil
+	})
+}
+
+// Prune destroys the specified version on behalf of the retention policy and marks it as pruned.
+func (s *S3Storage) Prune(ctx context.Context, keyPath string, version int) error {
+	return s.update(ctx, keyPath, version, func(record *kvRecord) error {
+		now := time.Now().UTC()
+		if record.DestroyedAt == nil {
+			record.DestroyedAt = &now
+		}
+		if record.PrunedAt == nil {
+			record.PrunedAt = &now
+		}
+		record.Contents = ""
+		record.HMAC = ""
+		record.KPID = ""
+		return nil
+	})
+}
+
+// UpdateKPId replaces the key provider blob of the specified version if it is still oldKPId,
+// and succeeds without a change if it already is newKPId.
+func (s *S3Storage) UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error {
+	return s.update(ctx, keyPath, version, func(record *kvRecord) error {
+		if record.DestroyedAt != nil {
+			return common.ErrVersionDestroyed
+		}
+		if record.KPID == newKPId {
+			return nil
+		}
+		if record.KPID != oldKPId {
+			return common.ErrVersionConflict
+		}
+		record.KPID = newKPId
+		return nil
+	})
+}
+
+// update applies fn to a stored version and writes it back only if the object is unchanged
+// since it was read, retrying when it was modified concurrently.
+func (s *S3Storage) update(ctx context.Context, keyPath string, version int, fn func(record *kvRecord) error) error {
+	key := s.objectKey(keyPath, version)
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		record, etag, err := s.get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve item: %v", err)
+		}
+		if record == nil {
+			return common.ErrVersionNotFound
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		err = s.put(ctx, key, body, "If-Match", etag)
+		if isPreconditionFailed(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update item: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to update %s version %d: modified concurrently", keyPath, version)
+}
+
+// get reads and decodes an object, returning nil if it does not exist.
+func (s *S3Storage) get(ctx context.Context, key string) (*kvRecord, string, error) {
+	output, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if statusCode(err) == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var record kvRecord
+	if err := json.Unmarshal(body, &record); err != nil {
+		return nil, "", err
+	}
+	return &record, aws.StringValue(output.ETag), nil
+}
+
+// put writes an object with a conditional header. The SDK does not model conditional
+// writes, so the header is added to the request directly.
+func (s *S3Storage) put(ctx context.Context, key string, body []byte, conditionHeader, conditionValue string) error {
+	req, _ := s.svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	req.SetContext(ctx)
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(conditionHeader, conditionValue)
+	})
+	return req.Send()
+}
+
+// objectKey is the name of the object holding a version.
+func (s *S3Storage) objectKey(keyPath string, version int) string {
+	return fmt.Sprintf("%s%s/%0*d", s.root, keyPath, versionDigits, version)
+}
+
+// parseVersion parses the last segment of an object key written by objectKey.
+func parseVersion(name string) (int, bool) {
+	if len(name) != versionDigits {
+		return 0, false
+	}
+	version, err := strconv.Atoi(name)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// statusCode returns the HTTP status code of a failed request, or 0.
+func statusCode(err error) int {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode()
+	}
+	return 0
+}
+
+// isPreconditionFailed reports whether a conditional write was rejected, either because the
+// condition did not hold or because a concurrent conditional write to the same object won.
+func isPreconditionFailed(err error) bool {
+	code := statusCode(err)
+	return code == http.StatusPreconditionFailed || code == http.StatusConflict
+}
+
+// parallel calls fn for 0..n-1 with at most batchConcurrency calls in flight.
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchConcurrency)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (r kvRecord) versionInfo(version int) common.VersionInfo {
+	return common.VersionInfo{
+		Version: version,
+		Metadata: common.Metadata{
+			CreatedAt:   r.CreatedAt,
+			Encryptor:   r.Encryptor,
+			KeyProvider: r.KeyProvider,
+			CreatedBy:   r.CreatedBy,
+		},
+		DeletedAt:   r.DeletedAt,
+		DestroyedAt: r.DestroyedAt,
+		PrunedAt:    r.PrunedAt,
+	}
+}
@@ -0,0 +1,241 @@
+package sqlmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Execer is the subset of *sql.Tx a migration needs to apply its changes.
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Migration is a single numbered schema change. Versions must be unique and are applied in ascending order.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx Execer) error
+}
+
+// Status reports whether a migration has been applied and when.
+type Status struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+// Dialect captures the few places where the supported SQL databases differ.
+type Dialect struct {
+	// Placeholder returns the bind parameter for the n-th (1-based) argument.
+	Placeholder func(n int) string
+	// CurrentSchema is the SQL expression naming the schema that unqualified table names resolve to.
+	CurrentSchema string
+	// Lock takes a session-level lock so that only one instance migrates at a time.
+	Lock func(ctx context.Context, conn *sql.Conn) error
+	// Unlock releases the lock taken by Lock.
+	Unlock func(ctx context.Context, conn *sql.Conn) error
+}
+
+// lockName identifies the advisory lock held while migrating.
+const lockName = "owlvault_schema_migrations"
+
+// MySQL is the Dialect for MySQL.
+var MySQL = Dialect{
+	Placeholder:   func(int) string { return "?" },
+	CurrentSchema: "DATABASE()",
+	Lock: func(ctx context.Context, conn *sql.Conn) error {
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", lockName).Scan(&acquired); err != nil {
+			return err
+		}
+		if acquired.Int64 != 1 {
+			return fmt.Errorf("timed out waiting for migration lock %q", lockName)
+		}
+		return nil
+	},
+	Unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+		return err
+	},
+}
+
+// PostgreSQL is the Dialect for PostgreSQL.
+var PostgreSQL = Dialect{
+	Placeholder:   func(n int) string { return fmt.Sprintf("$%d", n) },
+	CurrentSchema: "current_schema()",
+	Lock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", lockName)
+		return err
+	},
+	Unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", lockName)
+		return err
+	},
+}
+
+// SQL returns a migration step that executes the given statements in order.
+func SQL(statements ...string) func(tx Execer) error {
+	return func(tx Execer) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Run applies every migration that is not yet recorded in schema_migrations.
+func Run(ctx context.Context, db *sql.DB, dialect Dialect, migrations []Migration) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := dialect.Lock(ctx, conn); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	defer dialect.Unlock(ctx, conn)
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range sorted(migrations) {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if err := apply(ctx, conn, dialect, migration); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+		}
+	}
+
+	return nil
+}
+
+// Statuses lists every known migration together with when it was applied, if ever. It only
+// reads the database: without a schema_migrations table every migration is reported as pending.
+func Statuses(ctx context.Context, db *sql.DB, dialect Dialect, migrations []Migration) ([]Status, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	exists, err := tableExists(ctx, conn, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	if exists {
+		applied, err = appliedVersions(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []Status
+	for _, migration := range sorted(migrations) {
+		status := Status{
+			Version:     migration.Version,
+			Description: migration.Description,
+		}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// apply runs a single migration and records it in one transaction. Databases that
+// auto-commit DDL (MySQL) only get atomicity for the bookkeeping row, so migrations
+// should be written to tolerate being re-run.
+func apply(ctx context.Context, conn *sql.Conn, dialect Dialect, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migration.Up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		fmt.Sprintf("INSERT INTO schema_migrations (version, description) VALUES (%s, %s)", dialect.Placeholder(1), dialect.Placeholder(2)),
+		migration.Version, migration.Description,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INT NOT NULL PRIMARY KEY,
+            description VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	return nil
+}
+
+func tableExists(ctx context.Context, conn *sql.Conn, dialect Dialect) (bool, error) {
+	var tableCount int
+	err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+        SELECT COUNT(*) FROM information_schema.tables
+        WHERE table_schema = %s AND table_name = 'schema_migrations'
+    `, dialect.CurrentSchema)).Scan(&tableCount)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up schema_migrations table: %v", err)
+	}
+	return tableCount > 0, nil
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func sorted(migrations []Migration) []Migration {
+	out := append([]Migration(nil), migrations...)
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Version < out[j].Version
+	})
+	return out
+}
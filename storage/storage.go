@@ -1,25 +1,127 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ngoyal16/owlvault/config"
+	"github.com/ngoyal16/owlvault/storage/boltdb"
+	"github.com/ngoyal16/owlvault/storage/common"
 	"github.com/ngoyal16/owlvault/storage/ddb"
+	"github.com/ngoyal16/owlvault/storage/memory"
+	"github.com/ngoyal16/owlvault/storage/mongodb"
 	"github.com/ngoyal16/owlvault/storage/mysql"
+	"github.com/ngoyal16/owlvault/storage/postgresql"
+	"github.com/ngoyal16/owlvault/storage/redis"
+	"github.com/ngoyal16/owlvault/storage/s3"
+	"github.com/ngoyal16/owlvault/storage/sqlmigrate"
 )
 
+// ErrVersionConflict is returned by Store when another writer already holds the version.
+var ErrVersionConflict = common.ErrVersionConflict
+
+// ErrVersionNotFound is returned when the targeted version was never stored.
+var ErrVersionNotFound = common.ErrVersionNotFound
+
+// ErrVersionDeleted is returned by Retrieve for a soft-deleted version.
+var ErrVersionDeleted = common.ErrVersionDeleted
+
+// ErrVersionDestroyed is returned for a version whose contents have been erased.
+var ErrVersionDestroyed = common.ErrVersionDestroyed
+
+// ErrAtomicBatchTooLarge is returned by StoreAtomic when the batch exceeds what the backend can commit at once.
+var ErrAtomicBatchTooLarge = common.ErrAtomicBatchTooLarge
+
+// ErrInvalidToken is returned by List for a malformed continuation token.
+var ErrInvalidToken = common.ErrInvalidToken
+
+// VersionKey identifies a single stored version of a key path.
+type VersionKey = common.VersionKey
+
+// RetrieveResult is the outcome of retrieving one version in a batch.
+type RetrieveResult = common.RetrieveResult
+
+// KeySummary describes a stored key path and its latest version.
+type KeySummary = common.KeySummary
+
+// Record is a single stored version of a key path.
+type Record = common.Record
+
+// Metadata is recorded alongside each stored version.
+type Metadata = common.Metadata
+
+// VersionInfo describes a stored version and its deletion state without its contents.
+type VersionInfo = common.VersionInfo
+
 // Storage defines the interface for interacting with the storage backend.
 type Storage interface {
-	// Store stores the key-value pair with the specified version and timestamp.
-	Store(keyPath string, contents string, hmac string, kpId string, version int) error
+	// Store stores the record under its key path and version, along with its metadata.
+	// It must never overwrite an existing version and returns ErrVersionConflict instead.
+	Store(ctx context.Context, record Record) error
 
 	// Retrieve retrieves the value for the specified key and version.
-	Retrieve(keyPath string, version int) (string, string, string, error)
+	// Deleted and destroyed versions yield ErrVersionDeleted and ErrVersionDestroyed.
+	Retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error)
 
 	// LatestVersion returns the latest version of the value for the specified key.
-	LatestVersion(keyPath string) (int, error)
+	LatestVersion(ctx context.Context, keyPath string) (int, error)
+
+	// BatchStore stores the records like Store, in as few round trips as the backend allows.
+	// The returned errors line up with records; a nil error means the record was stored.
+	// The second return value reports a failure of the batch as a whole.
+	BatchStore(ctx context.Context, records []Record) ([]error, error)
+
+	// StoreAtomic stores all of the records or none of them. If any record's version already
+	// exists it stores nothing and returns ErrVersionConflict.
+	StoreAtomic(ctx context.Context, records []Record) error
+
+	// BatchRetrieve retrieves the given versions like Retrieve, in as few round trips as the
+	// backend allows. The returned results line up with keys.
+	BatchRetrieve(ctx context.Context, keys []VersionKey) ([]RetrieveResult, error)
+
+	// BatchLatestVersion returns the latest version of each key path. Key paths without any
+	// stored version are absent from the result.
+	BatchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error)
 
-	Migrate() error // New method for migrations
+	// Versions describes every stored version of the key path in ascending order, without contents.
+	Versions(ctx context.Context, keyPath string) ([]VersionInfo, error)
+
+	// Export returns every stored version of the key path in ascending order, including contents,
+	// so that it can be copied to another backend without decrypting it.
+	Export(ctx context.Context, keyPath string) ([]Record, error)
+
+	// List returns up to limit key paths starting with prefix, resuming after the continuation token
+	// of a previous call. The returned token is empty once there are no more key paths.
+	List(ctx context.Context, prefix string, limit int, token string) ([]KeySummary, string, error)
+
+	// Delete soft-deletes the specified version so that it can later be recovered with Undelete.
+	Delete(ctx context.Context, keyPath string, version int) error
+
+	// Undelete recovers a soft-deleted version.
+	Undelete(ctx context.Context, keyPath string, version int) error
+
+	// Destroy permanently erases the contents of the specified version, keeping its version number reserved.
+	Destroy(ctx context.Context, keyPath string, version int) error
+
+	// Prune destroys the specified version on behalf of the retention policy and records that it was pruned.
+	Prune(ctx context.Context, keyPath string, version int) error
+
+	// UpdateKPId replaces the key provider blob of the specified version, leaving its contents
+	// untouched, if it is still oldKPId. A version that already holds newKPId is left as it is and
+	// is not an error, so that an interrupted update can be retried. It returns ErrVersionConflict
+	// if the version holds any other blob and ErrVersionDestroyed if the version has been destroyed.
+	UpdateKPId(ctx context.Context, keyPath string, version int, oldKPId, newKPId string) error
+
+	// Ping checks that the storage is reachable and ready to serve requests.
+	Ping(ctx context.Context) error
+
+	Migrate(ctx context.Context) error // New method for migrations
+}
+
+// MigrationInspector is implemented by storage backends that keep a versioned schema history.
+type MigrationInspector interface {
+	// MigrationStatus lists every known schema migration and whether it has been applied.
+	MigrationStatus(ctx context.Context) ([]sqlmigrate.Status, error)
 }
 
 // StorageType represents the type of storage.
@@ -28,13 +130,43 @@ type StorageType string
 const (
 	// MYSQL represents the MySQL storage solution.
 	MYSQL StorageType = "mysql"
+	// POSTGRESQL represents the PostgreSQL storage solution.
+	POSTGRESQL StorageType = "postgresql"
+	// MONGODB represents the MongoDB storage solution.
+	MONGODB StorageType = "mongodb"
 	// DDB represents the DynamoDB storage solution.
 	DDB StorageType = "dynamodb"
+	// BOLTDB represents the embedded single-file bbolt storage solution.
+	BOLTDB StorageType = "boltdb"
+	// MEMORY represents the non-persistent in-memory storage solution.
+	MEMORY StorageType = "memory"
+	// REDIS represents the Redis storage solution.
+	REDIS StorageType = "redis"
+	// S3 represents S3-compatible object storage.
+	S3 StorageType = "s3"
+	// MIRROR represents two of the other storage solutions written side by side.
+	MIRROR StorageType = "mirror"
 	// Add more storage solution as needed
 )
 
-// NewStorage initializes and returns the appropriate storage implementation based on the configuration.
-func NewStorage(cfg *config.Config) (Storage, error) {
+// NewStorage initializes the configured storage implementation and applies its pending migrations.
+func NewStorage(ctx context.Context, cfg *config.Config) (Storage, error) {
+	dbStorage, err := OpenStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dbStorage.Migrate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbStorage, nil
+}
+
+// OpenStorage initializes and returns the appropriate storage implementation based on the configuration
+// without running migrations.
+func OpenStorage(cfg *config.Config) (Storage, error) {
 	var dbStorage Storage
 	var err error
 
@@ -43,8 +175,22 @@ func NewStorage(cfg *config.Config) (Storage, error) {
 	switch storageType {
 	case MYSQL:
 		dbStorage, err = mysql.NewMySQLStorage(cfg.Storage.MySQL.ConnectionString)
+	case POSTGRESQL:
+		dbStorage, err = postgresql.NewPostgreSQLStorage(cfg.Storage.PostgreSQL.ConnectionString)
+	case MONGODB:
+		dbStorage, err = mongodb.NewMongoDBStorage(cfg.Storage.MongoDB.ConnectionString, cfg.Storage.MongoDB.DatabaseName, cfg.Storage.MongoDB.CollectionName)
 	case DDB:
-		dbStorage, err = ddb.NewDynamoDBStorage(cfg.Storage.DDB.Region, cfg.Storage.DDB.TablePrefix)
+		dbStorage, err = ddb.NewDynamoDBStorage(cfg.Storage.DDB.Region, cfg.Storage.DDB.TablePrefix, ddbOptions(cfg)...)
+	case BOLTDB:
+		dbStorage, err = boltdb.NewBoltDBStorage(cfg.Storage.BoltDB.Path)
+	case MEMORY:
+		dbStorage, err = memory.NewMemoryStorage()
+	case REDIS:
+		dbStorage, err = redis.NewRedisStorage(cfg.Storage.Redis.ConnectionString, cfg.Storage.Redis.KeyPrefix)
+	case S3:
+		dbStorage, err = s3.NewS3Storage(cfg.Storage.S3.Region, cfg.Storage.S3.Bucket, cfg.Storage.S3.Prefix, s3Options(cfg)...)
+	case MIRROR:
+		dbStorage, err = openMirrorStorage(cfg)
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Storage.Type)
 	}
@@ -53,10 +199,77 @@ func NewStorage(cfg *config.Config) (Storage, error) {
 		return nil, err
 	}
 
-	err = dbStorage.Migrate()
+	return dbStorage, nil
+}
+
+// openMirrorStorage opens the primary and secondary storages of a mirror. Both are configured by
+// their own blocks of the same storage configuration.
+func openMirrorStorage(cfg *config.Config) (Storage, error) {
+	primaryType, secondaryType := cfg.Storage.Mirror.PrimaryType, cfg.Storage.Mirror.SecondaryType
+	if primaryType == "" || secondaryType == "" {
+		return nil, fmt.Errorf("mirror storage requires both primary_type and secondary_type")
+	}
+	if primaryType == secondaryType {
+		return nil, fmt.Errorf("mirror storage requires two different storage types, got %s twice", primaryType)
+	}
+	if StorageType(primaryType) == MIRROR || StorageType(secondaryType) == MIRROR {
+		return nil, fmt.Errorf("mirror storage cannot mirror itself")
+	}
+
+	primaryCfg := *cfg
+	primaryCfg.Storage.Type = primaryType
+	primary, err := OpenStorage(&primaryCfg)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("primary: %v", err)
 	}
 
-	return dbStorage, nil
+	secondaryCfg := *cfg
+	secondaryCfg.Storage.Type = secondaryType
+	secondary, err := OpenStorage(&secondaryCfg)
+	if err != nil {
+		return nil, fmt.Errorf("secondary: %v", err)
+	}
+
+	return NewMirrorStorage(primary, secondary), nil
+}
+
+// ddbOptions translates the DynamoDB configuration into storage options, leaving unset values at their defaults.
+func ddbOptions(cfg *config.Config) []ddb.Option {
+	ddbCfg := cfg.Storage.DDB
+
+	var opts []ddb.Option
+	if ddbCfg.Endpoint != "" {
+		opts = append(opts, ddb.WithEndpoint(ddbCfg.Endpoint))
+	}
+
+	billingMode := ddbCfg.BillingMode
+	readCapacityUnits, writeCapacityUnits := ddbCfg.ReadCapacityUnits, ddbCfg.WriteCapacityUnits
+	if billingMode == "" {
+		billingMode = ddb.BillingModeProvisioned
+	}
+	if billingMode == ddb.BillingModeProvisioned {
+		if readCapacityUnits == 0 {
+			readCapacityUnits = 5
+		}
+		if writeCapacityUnits == 0 {
+			writeCapacityUnits = 5
+		}
+	}
+
+	opts = append(opts,
+		ddb.WithBillingMode(billingMode, readCapacityUnits, writeCapacityUnits),
+		ddb.WithPointInTimeRecovery(ddbCfg.PointInTimeRecovery),
+		ddb.WithSSE(ddbCfg.SSE.Enabled, ddbCfg.SSE.KMSKeyId),
+		ddb.WithTags(ddbCfg.Tags),
+	)
+	return opts
+}
+
+// s3Options translates the S3 configuration into storage options.
+func s3Options(cfg *config.Config) []s3.Option {
+	var opts []s3.Option
+	if cfg.Storage.S3.Endpoint != "" {
+		opts = append(opts, s3.WithEndpoint(cfg.Storage.S3.Endpoint))
+	}
+	return append(opts, s3.WithPathStyle(cfg.Storage.S3.ForcePathStyle))
 }
@@ -0,0 +1,270 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ngoyal16/owlvault/storage"
+)
+
+// StoreRequest is one key path and its data to store with StoreBatch or StoreAtomic.
+// When ExpectedVersion is set the write is a check-and-set, as with StoreDataIfVersion.
+type StoreRequest struct {
+	KeyPath         string
+	Data            map[string]interface{}
+	ExpectedVersion *int
+}
+
+// StoreResult is the outcome of one StoreRequest: the version stored, or why it was not.
+type StoreResult struct {
+	KeyPath string
+	Version int
+	Err     error
+}
+
+// RetrieveRequest names a version to retrieve with RetrieveBatch. Version 0 means the latest version.
+type RetrieveRequest struct {
+	KeyPath string
+	Version int
+}
+
+// RetrieveResult is the outcome of one RetrieveRequest: the decrypted data, or why it could not be read.
+type RetrieveResult struct {
+	KeyPath string
+	Data    map[string]interface{}
+	Err     error
+}
+
+// StoreBatch stores each request under the next free version of its key path, like StoreData,
+// using batch storage calls so that the whole batch takes a few round trips rather than a few
+// per key. Requests for the same key path get consecutive versions. The results line up with
+// requests; an error is only returned when the batch as a whole failed.
+func (ov *OwlVault) StoreBatch(ctx context.Context, requests []StoreRequest) ([]StoreResult, error) {
+	results := make([]StoreResult, len(requests))
+	records := make([]storage.Record, len(requests))
+
+	var pending []int
+	for i, request := range requests {
+		results[i].KeyPath = request.KeyPath
+
+		record, err := ov.seal(ctx, request.KeyPath, request.Data)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		records[i] = record
+		pending = append(pending, i)
+	}
+
+	for attempt := 0; attempt < maxStoreAttempts && len(pending) > 0; attempt++ {
+		var keyPaths []string
+		seen := make(map[string]bool)
+		for _, i := range pending {
+			if !seen[records[i].KeyPath] {
+				seen[records[i].KeyPath] = true
+				keyPaths = append(keyPaths, records[i].KeyPath)
+			}
+		}
+
+		latest, err := ov.batchLatestVersion(ctx, keyPaths)
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []storage.Record
+		var writing []int
+		for _, i := range pending {
+			keyPath := records[i].KeyPath
+			if err := checkExpectedVersion(keyPath, latest[keyPath], requests[i].ExpectedVersion); err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			latest[keyPath]++
+			records[i].Version = latest[keyPath]
+			batch = append(batch, records[i])
+			writing = append(writing, i)
+		}
+
+		errs, err := ov.batchStore(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store key-value pairs: %v", err)
+		}
+
+		// Check-and-set writes that conflicted notice the other writer on the next check
+		var conflicted []int
+		for j, i := range writing {
+			switch {
+			case errors.Is(errs[j], storage.ErrVersionConflict):
+				conflicted = append(conflicted, i)
+			case errs[j] != nil:
+				results[i].Err = fmt.Errorf("failed to store key-value pair: %v", errs[j])
+			default:
+				results[i].Version = records[i].Version
+			}
+		}
+		pending = conflicted
+	}
+
+	for _, i := range pending {
+		results[i].Err = fmt.Errorf("failed to store key-value pair after %d attempts: %w", maxStoreAttempts, storage.ErrVersionConflict)
+	}
+
+	// The writes already succeeded, so a pruning failure is left to the background pruner
+	pruned := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil || pruned[result.KeyPath] {
+			continue
+		}
+		pruned[result.KeyPath] = true
+		if _, err := ov.PruneKey(ctx, result.KeyPath); err != nil {
+			log.Printf("failed to apply retention policy to %s: %v", result.KeyPath, err)
+		}
+	}
+
+	return results, nil
+}
+
+// StoreAtomic stores every request under the next free version of its key path, or none
+// of them. Requests for the same key path get consecutive versions. If another writer takes
+// one of the versions first, the whole batch is retried with fresh versions, unless that
+// makes a check-and-set request fail with ErrVersionMismatch.
+func (ov *OwlVault) StoreAtomic(ctx context.Context, requests []StoreRequest) ([]StoreResult, error) {
+	records := make([]storage.Record, len(requests))
+	var keyPaths []string
+	seen := make(map[string]bool)
+	for i, request := range requests {
+		record, err := ov.seal(ctx, request.KeyPath, request.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", request.KeyPath, err)
+		}
+		records[i] = record
+
+		if !seen[request.KeyPath] {
+			seen[request.KeyPath] = true
+			keyPaths = append(keyPaths, request.KeyPath)
+		}
+	}
+
+	for attempt := 0; attempt < maxStoreAttempts; attempt++ {
+		latest, err := ov.batchLatestVersion(ctx, keyPaths)
+		if err != nil {
+			return nil, err
+		}
+		for i := range records {
+			keyPath := records[i].KeyPath
+			if err := checkExpectedVersion(keyPath, latest[keyPath], requests[i].ExpectedVersion); err != nil {
+				return nil, err
+			}
+
+			latest[keyPath]++
+			records[i].Version = latest[keyPath]
+		}
+
+		err = ov.storeAtomic(ctx, records)
+		if errors.Is(err, storage.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to store key-value pairs: %w", err)
+		}
+
+		results := make([]StoreResult, len(records))
+		for i, record := range records {
+			results[i] = StoreResult{KeyPath: record.KeyPath, Version: record.Version}
+		}
+
+		// The writes already succeeded, so a pruning failure is left to the background pruner
+		for _, keyPath := range keyPaths {
+			if _, err := ov.PruneKey(ctx, keyPath); err != nil {
+				log.Printf("failed to apply retention policy to %s: %v", keyPath, err)
+			}
+		}
+		return results, nil
+	}
+
+	return nil, fmt.Errorf("failed to store key-value pairs after %d attempts: %w", maxStoreAttempts, storage.ErrVersionConflict)
+}
+
+// RetrieveBatch retrieves and decrypts each requested version, like RetrieveVersion and
+// RetrieveLatestVersion, using batch storage calls. The results line up with requests;
+// an error is only returned when the batch as a whole failed.
+func (ov *OwlVault) RetrieveBatch(ctx context.Context, requests []RetrieveRequest) ([]RetrieveResult, error) {
+	results := make([]RetrieveResult, len(requests))
+
+	var latestPaths []string
+	for i, request := range requests {
+		results[i].KeyPath = request.KeyPath
+		if request.Version == 0 {
+			latestPaths = append(latestPaths, request.KeyPath)
+		}
+	}
+
+	var latest map[string]int
+	if len(latestPaths) > 0 {
+		var err error
+		latest, err = ov.batchLatestVersion(ctx, latestPaths)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var keys []storage.VersionKey
+	var indexes []int
+	for i, request := range requests {
+		version := request.Version
+		if version == 0 {
+			version = latest[request.KeyPath]
+		}
+		if version < 1 {
+			results[i].Err = ErrKeyNotFound
+			continue
+		}
+		keys = append(keys, storage.VersionKey{KeyPath: request.KeyPath, Version: version})
+		indexes = append(indexes, i)
+	}
+
+	stored, err := ov.batchRetrieve(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, i := range indexes {
+		switch {
+		case stored[j].Err != nil:
+			results[i].Err = stored[j].Err
+		case stored[j].Contents == "":
+			results[i].Err = ErrKeyNotFound
+		default:
+			results[i].Data, results[i].Err = ov.open(ctx, keys[j].KeyPath, stored[j].Contents, stored[j].HMAC, stored[j].KPId)
+		}
+	}
+
+	return results, nil
+}
+
+func (ov *OwlVault) batchStore(ctx context.Context, records []storage.Record) ([]error, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageWrite)
+	defer cancel()
+	return ov.storage.BatchStore(ctx, records)
+}
+
+func (ov *OwlVault) storeAtomic(ctx context.Context, records []storage.Record) error {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageWrite)
+	defer cancel()
+	return ov.storage.StoreAtomic(ctx, records)
+}
+
+func (ov *OwlVault) batchRetrieve(ctx context.Context, keys []storage.VersionKey) ([]storage.RetrieveResult, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	defer cancel()
+	return ov.storage.BatchRetrieve(ctx, keys)
+}
+
+func (ov *OwlVault) batchLatestVersion(ctx context.Context, keyPaths []string) (map[string]int, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	defer cancel()
+	return ov.storage.BatchLatestVersion(ctx, keyPaths)
+}
@@ -0,0 +1,53 @@
+package vault
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Names of the components reported by CheckHealth.
+const (
+	ComponentStorage     = "storage"
+	ComponentKeyProvider = "keyProvider"
+)
+
+// ComponentHealth is the outcome of checking one component the vault depends on.
+type ComponentHealth struct {
+	Name    string
+	Latency time.Duration
+	Err     error
+}
+
+// CheckHealth pings the storage and the key provider concurrently, each bounded by its
+// read timeout, and reports the outcome of every component in a fixed order.
+func (ov *OwlVault) CheckHealth(ctx context.Context) []ComponentHealth {
+	checks := []struct {
+		name    string
+		timeout time.Duration
+		ping    func(ctx context.Context) error
+	}{
+		{ComponentStorage, ov.timeouts.StorageRead, ov.storage.Ping},
+		{ComponentKeyProvider, ov.timeouts.KeyProvider, ov.keyProvider.Ping},
+	}
+
+	results := make([]ComponentHealth, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx, cancel := withTimeout(ctx, check.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.ping(ctx)
+			results[i] = ComponentHealth{Name: check.name, Latency: time.Since(start), Err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
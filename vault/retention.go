@@ -0,0 +1,177 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ngoyal16/owlvault/storage"
+)
+
+// RetentionPolicy bounds the history kept for a key path. A zero field means no limit.
+type RetentionPolicy struct {
+	// MaxVersions is the number of most recent versions to keep.
+	MaxVersions int
+	// MaxAge is how long a version is kept after it was written.
+	MaxAge time.Duration
+}
+
+// RetentionRule applies its policy instead of the default one to key paths starting with Prefix.
+type RetentionRule struct {
+	Prefix string
+	RetentionPolicy
+}
+
+// Retention configures which versions are pruned. The rule with the longest matching
+// prefix wins; key paths matching no rule fall back to Default.
+type Retention struct {
+	Default RetentionPolicy
+	Rules   []RetentionRule
+}
+
+// WithRetention sets the retention policy enforced after every write and by the background pruner.
+func WithRetention(retention Retention) Option {
+	return func(ov *OwlVault) {
+		ov.retention = retention
+	}
+}
+
+// enabled reports whether the policy limits anything.
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxVersions > 0 || p.MaxAge > 0
+}
+
+// enabled reports whether any key path can be subject to pruning.
+func (r Retention) enabled() bool {
+	if r.Default.enabled() {
+		return true
+	}
+	for _, rule := range r.Rules {
+		if rule.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// policyFor returns the policy that applies to keyPath.
+func (r Retention) policyFor(keyPath string) RetentionPolicy {
+	policy := r.Default
+	matched := -1
+	for _, rule := range r.Rules {
+		if strings.HasPrefix(keyPath, rule.Prefix) && len(rule.Prefix) > matched {
+			policy = rule.RetentionPolicy
+			matched = len(rule.Prefix)
+		}
+	}
+	return policy
+}
+
+// expired returns the versions the policy no longer keeps. The latest version is always
+// kept, as are versions already destroyed and versions without a recorded creation time
+// when only their age would expire them.
+func (p RetentionPolicy) expired(versions []storage.VersionInfo, now time.Time) []int {
+	var expired []int
+	for i, version := range versions {
+		if i == len(versions)-1 || version.DestroyedAt != nil {
+			continue
+		}
+
+		tooMany := p.MaxVersions > 0 && i < len(versions)-p.MaxVersions
+		tooOld := p.MaxAge > 0 && !version.Metadata.CreatedAt.IsZero() && now.Sub(version.Metadata.CreatedAt) > p.MaxAge
+		if tooMany || tooOld {
+			expired = append(expired, version.Version)
+		}
+	}
+	return expired
+}
+
+// PruneKey destroys the versions of keyPath that its retention policy no longer keeps
+// and returns them.
+func (ov *OwlVault) PruneKey(ctx context.Context, keyPath string) ([]int, error) {
+	policy := ov.retention.policyFor(keyPath)
+	if !policy.enabled() {
+		return nil, nil
+	}
+
+	readCtx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	versions, err := ov.storage.Versions(readCtx, keyPath)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []int
+	for _, version := range policy.expired(versions, time.Now()) {
+		writeCtx, cancel := withTimeout(ctx, ov.timeouts.StorageWrite)
+		err := ov.storage.Prune(writeCtx, keyPath, version)
+		cancel()
+		if err != nil {
+			return pruned, fmt.Errorf("version %d: %w", version, err)
+		}
+		pruned = append(pruned, version)
+	}
+
+	return pruned, nil
+}
+
+// PruneAll applies the retention policy to every stored key path and returns how many
+// versions were pruned. It carries on past key paths that fail and reports the first error.
+func (ov *OwlVault) PruneAll(ctx context.Context) (int, error) {
+	if !ov.retention.enabled() {
+		return 0, nil
+	}
+
+	var count int
+	var firstErr error
+	token := ""
+	for {
+		summaries, next, err := ov.ListKeys(ctx, "", MaxListLimit, token)
+		if err != nil {
+			return count, fmt.Errorf("failed to list keys: %v", err)
+		}
+
+		for _, summary := range summaries {
+			pruned, err := ov.PruneKey(ctx, summary.KeyPath)
+			count += len(pruned)
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("failed to prune %s: %w", summary.KeyPath, err)
+			}
+		}
+
+		if next == "" {
+			return count, firstErr
+		}
+		token = next
+	}
+}
+
+// StartPruner runs PruneAll every interval until ctx is cancelled. It does nothing when
+// no retention policy is configured or interval is not positive.
+func (ov *OwlVault) StartPruner(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || !ov.retention.enabled() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := ov.PruneAll(ctx)
+				if err != nil {
+					log.Printf("retention pruner: %v", err)
+				}
+				if count > 0 {
+					log.Printf("retention pruner: pruned %d versions", count)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,192 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ngoyal16/owlvault/storage"
+)
+
+func TestRetentionPolicyFor(t *testing.T) {
+	retention := Retention{
+		Default: RetentionPolicy{MaxVersions: 10},
+		Rules: []RetentionRule{
+			{Prefix: "app/", RetentionPolicy: RetentionPolicy{MaxVersions: 5}},
+			{Prefix: "app/db/", RetentionPolicy: RetentionPolicy{MaxVersions: 2}},
+			{Prefix: "tmp/", RetentionPolicy: RetentionPolicy{MaxAge: time.Hour}},
+		},
+	}
+
+	tests := []struct {
+		keyPath string
+		want    RetentionPolicy
+	}{
+		{keyPath: "other", want: RetentionPolicy{MaxVersions: 10}},
+		{keyPath: "app/smtp", want: RetentionPolicy{MaxVersions: 5}},
+		{keyPath: "app/db/primary", want: RetentionPolicy{MaxVersions: 2}},
+		{keyPath: "tmp/session", want: RetentionPolicy{MaxAge: time.Hour}},
+	}
+
+	for _, tt := range tests {
+		if got := retention.policyFor(tt.keyPath); got != tt.want {
+			t.Errorf("policyFor(%q): got %+v, want %+v", tt.keyPath, got, tt.want)
+		}
+	}
+}
+
+func TestRetentionPolicyExpired(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	destroyed := now.Add(-time.Minute)
+
+	// Versions 1 to 5, written an hour apart up to a minute before the check; version 2 is
+	// already destroyed and version 3 has no recorded creation time
+	versions := make([]storage.VersionInfo, 5)
+	for i := range versions {
+		versions[i] = storage.VersionInfo{Version: i + 1}
+		versions[i].Metadata.CreatedAt = now.Add(-time.Duration(4-i) * time.Hour)
+	}
+	versions[1].DestroyedAt = &destroyed
+	versions[2].Metadata.CreatedAt = time.Time{}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []int
+	}{
+		{name: "no limit", policy: RetentionPolicy{}},
+		{name: "max versions", policy: RetentionPolicy{MaxVersions: 2}, want: []int{1, 3}},
+		{name: "max versions above the count", policy: RetentionPolicy{MaxVersions: 10}},
+		{name: "max age", policy: RetentionPolicy{MaxAge: 90 * time.Minute}, want: []int{1}},
+		{name: "max age keeps the latest version", policy: RetentionPolicy{MaxAge: time.Nanosecond}, want: []int{1, 4}},
+		{name: "either limit", policy: RetentionPolicy{MaxVersions: 4, MaxAge: 30 * time.Minute}, want: []int{1, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.expired(versions, now.Add(time.Minute)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		retention  Retention
+		keyPath    string
+		wantPruned []int
+	}{
+		{name: "no retention", keyPath: "kv"},
+		{name: "default policy", retention: Retention{Default: RetentionPolicy{MaxVersions: 2}}, keyPath: "kv", wantPruned: []int{1, 2}},
+		{
+			name: "rule overrides the default",
+			retention: Retention{
+				Default: RetentionPolicy{MaxVersions: 1},
+				Rules:   []RetentionRule{{Prefix: "app/", RetentionPolicy: RetentionPolicy{MaxVersions: 3}}},
+			},
+			keyPath:    "app/db",
+			wantPruned: []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Storing applies the policy too, so the versions are written without it first
+			ov := newTestVault(t, nil)
+			for i := 1; i <= 4; i++ {
+				if _, err := ov.StoreData(ctx, tt.keyPath, map[string]interface{}{"version": i}); err != nil {
+					t.Fatalf("StoreData: %v", err)
+				}
+			}
+			ov.retention = tt.retention
+
+			pruned, err := ov.PruneKey(ctx, tt.keyPath)
+			if err != nil {
+				t.Fatalf("PruneKey: %v", err)
+			}
+			if len(pruned) != 0 || len(tt.wantPruned) != 0 {
+				if !reflect.DeepEqual(pruned, tt.wantPruned) {
+					t.Errorf("PruneKey: pruned %v, want %v", pruned, tt.wantPruned)
+				}
+			}
+
+			versions, err := ov.DescribeKey(ctx, tt.keyPath)
+			if err != nil {
+				t.Fatalf("DescribeKey: %v", err)
+			}
+			for _, version := range versions {
+				wantPruned := false
+				for _, v := range tt.wantPruned {
+					wantPruned = wantPruned || v == version.Version
+				}
+				if (version.PrunedAt != nil) != wantPruned || (version.DestroyedAt != nil) != wantPruned {
+					t.Errorf("version %d: got pruned at %v and destroyed at %v, want pruned %t",
+						version.Version, version.PrunedAt, version.DestroyedAt, wantPruned)
+				}
+
+				_, err := ov.RetrieveVersion(ctx, tt.keyPath, version.Version)
+				if wantPruned && !errors.Is(err, storage.ErrVersionDestroyed) {
+					t.Errorf("version %d: got error %v, want %v", version.Version, err, storage.ErrVersionDestroyed)
+				}
+				if !wantPruned && err != nil {
+					t.Errorf("version %d: %v", version.Version, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreDataAppliesRetention(t *testing.T) {
+	ctx := context.Background()
+	ov := newTestVault(t, nil, WithRetention(Retention{Default: RetentionPolicy{MaxVersions: 2}}))
+
+	for i := 1; i <= 3; i++ {
+		if _, err := ov.StoreData(ctx, "a", map[string]interface{}{"version": i}); err != nil {
+			t.Fatalf("StoreData: %v", err)
+		}
+	}
+	if _, err := ov.RetrieveVersion(ctx, "a", 1); !errors.Is(err, storage.ErrVersionDestroyed) {
+		t.Errorf("version 1: got error %v, want %v", err, storage.ErrVersionDestroyed)
+	}
+
+	// Pruning everything again finds nothing left to prune
+	count, err := ov.PruneAll(ctx)
+	if err != nil {
+		t.Fatalf("PruneAll: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("PruneAll: pruned %d versions, want 0", count)
+	}
+}
+
+func TestPruneAll(t *testing.T) {
+	ctx := context.Background()
+	ov := newTestVault(t, nil)
+
+	keyPaths := []string{"a", "b", "c/d"}
+	for _, keyPath := range keyPaths {
+		for i := 1; i <= 3; i++ {
+			if _, err := ov.StoreData(ctx, keyPath, map[string]interface{}{"version": i}); err != nil {
+				t.Fatalf("StoreData: %v", err)
+			}
+		}
+	}
+	ov.retention = Retention{
+		Default: RetentionPolicy{MaxVersions: 1},
+		Rules:   []RetentionRule{{Prefix: "c/", RetentionPolicy: RetentionPolicy{MaxVersions: 2}}},
+	}
+
+	count, err := ov.PruneAll(ctx)
+	if err != nil {
+		t.Fatalf("PruneAll: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("PruneAll: pruned %d versions, want 5", count)
+	}
+}
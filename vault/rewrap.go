@@ -0,0 +1,104 @@
+package vault
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/ngoyal16/owlvault/keyprovider"
+	"github.com/ngoyal16/owlvault/storage"
+)
+
+// RewrapResult counts what RewrapKey did with the versions of a key path.
+type RewrapResult struct {
+	// Rewrapped versions now have their data key wrapped by the vault's key provider.
+	Rewrapped int
+	// Current versions could already be read with the vault's key provider.
+	Current int
+	// Destroyed versions have no data key left to re-wrap.
+	Destroyed int
+}
+
+// RewrapKey re-wraps the data key of every version of keyPath, including deleted ones, that
+// was wrapped by from under the vault's key provider, so that a master key can be rotated
+// without re-encrypting the contents. Only the key provider blob of each version is replaced,
+// and only if it has not changed in the meantime. Versions from can no longer read but the
+// vault's key provider can, such as those re-wrapped by an interrupted run, are left as they are.
+func (ov *OwlVault) RewrapKey(ctx context.Context, keyPath string, from keyprovider.KeyProvider) (RewrapResult, error) {
+	var result RewrapResult
+
+	readCtx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	records, err := ov.storage.Export(readCtx, keyPath)
+	cancel()
+	if err != nil {
+		return result, err
+	}
+
+	for _, record := range records {
+		// Destroying a version erases its key provider blob along with its contents
+		if record.KPId == "" {
+			result.Destroyed++
+			continue
+		}
+
+		rewrapped, err := ov.rewrapVersion(ctx, record, from)
+		if errors.Is(err, storage.ErrVersionDestroyed) {
+			result.Destroyed++
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("version %d: %w", record.Version, err)
+		}
+		if rewrapped {
+			result.Rewrapped++
+		} else {
+			result.Current++
+		}
+	}
+
+	return result, nil
+}
+
+// rewrapVersion re-wraps the data key of a single version and reports whether it had to.
+func (ov *OwlVault) rewrapVersion(ctx context.Context, record storage.Record, from keyprovider.KeyProvider) (bool, error) {
+	blob, err := base64.StdEncoding.DecodeString(record.KPId)
+	if err != nil {
+		return false, fmt.Errorf("key provider id is not valid base64: %v", err)
+	}
+	encryptionContext := ov.encryptionContext(record.KeyPath)
+
+	kpCtx, cancel := withTimeout(ctx, ov.timeouts.KeyProvider)
+	encKey, hmacKey, err := from.RetrieveKey(kpCtx, blob, encryptionContext)
+	cancel()
+	if err != nil {
+		if _, _, currentErr := ov.retrieveKey(ctx, record.KeyPath, blob); currentErr == nil {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+
+	kpCtx, cancel = withTimeout(ctx, ov.timeouts.KeyProvider)
+	newBlob, err := ov.keyProvider.WrapKey(kpCtx, encKey, hmacKey, encryptionContext)
+	cancel()
+	if err != nil {
+		return false, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+
+	// The stored blob is the only copy of the data key, so check the new one before replacing it
+	checkEncKey, checkHMACKey, err := ov.retrieveKey(ctx, record.KeyPath, newBlob)
+	if err != nil {
+		return false, fmt.Errorf("failed to unwrap re-wrapped data key: %v", err)
+	}
+	if !hmac.Equal(checkEncKey, encKey) || !hmac.Equal(checkHMACKey, hmacKey) {
+		return false, fmt.Errorf("re-wrapped data key does not match the original")
+	}
+
+	writeCtx, cancel := withTimeout(ctx, ov.timeouts.StorageWrite)
+	defer cancel()
+	if err := ov.storage.UpdateKPId(writeCtx, record.KeyPath, record.Version, record.KPId, base64.StdEncoding.EncodeToString(newBlob)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -1,87 +1,353 @@
 package vault
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/ngoyal16/owlvault/encrypt"
 	"github.com/ngoyal16/owlvault/keyprovider"
 	"github.com/ngoyal16/owlvault/storage"
 )
 
+// ErrKeyNotFound is returned when the requested key path or version holds no data.
+var ErrKeyNotFound = errors.New("NO_KEY_FOUND")
+
+// ErrVersionMismatch is returned by check-and-set writes when the key path is no longer at the expected version.
+var ErrVersionMismatch = errors.New("VERSION_MISMATCH")
+
+// DefaultListLimit and MaxListLimit bound the page size of ListKeys.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// maxStoreAttempts bounds how often StoreData retries after losing a version race.
+const maxStoreAttempts = 5
+
 // OwlVault represents the key vault service.
 type OwlVault struct {
 	encryptor   encrypt.Encryptor
 	storage     storage.Storage
 	keyProvider keyprovider.KeyProvider
+
+	timeouts Timeouts
+
+	encryptorType   string
+	keyProviderType string
+	tenant          string
+
+	retention Retention
+}
+
+// Timeouts bounds how long a single storage or key provider call may take.
+// A zero value leaves the call bounded only by the caller's context.
+type Timeouts struct {
+	StorageRead  time.Duration
+	StorageWrite time.Duration
+	KeyProvider  time.Duration
+}
+
+// Option represents an option for configuring a new OwlVault.
+type Option func(*OwlVault)
+
+// WithTimeouts sets the per-operation timeouts applied to storage and key provider calls.
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(ov *OwlVault) {
+		ov.timeouts = timeouts
+	}
+}
+
+// WithComponentTypes records the configured encryptor and key provider types in the metadata of stored versions.
+func WithComponentTypes(encryptorType, keyProviderType string) Option {
+	return func(ov *OwlVault) {
+		ov.encryptorType = encryptorType
+		ov.keyProviderType = keyProviderType
+	}
+}
+
+// Keys of the encryption context data keys are bound to.
+const (
+	EncryptionContextKeyPath = "owlvault:key_path"
+	EncryptionContextTenant  = "owlvault:tenant"
+)
+
+// WithTenant adds the tenant to the encryption context of every data key. Data stored with one
+// tenant cannot be read with another, so it must not change once data has been stored.
+func WithTenant(tenant string) Option {
+	return func(ov *OwlVault) {
+		ov.tenant = tenant
+	}
+}
+
+// callerKey is the context key under which the caller identity is stored.
+type callerKey struct{}
+
+// ContextWithCaller returns a copy of ctx carrying the identity of the caller, recorded as created_by.
+func ContextWithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller identity carried by ctx, if any.
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
 }
 
 // NewOwlVault creates a new instance of OwlVault with the given storage.
-func NewOwlVault(storage storage.Storage, keyProvider keyprovider.KeyProvider, encryptor encrypt.Encryptor) *OwlVault {
-	return &OwlVault{
+func NewOwlVault(storage storage.Storage, keyProvider keyprovider.KeyProvider, encryptor encrypt.Encryptor, opts ...Option) *OwlVault {
+	ov := &OwlVault{
 		encryptor:   encryptor,
 		keyProvider: keyProvider,
 		storage:     storage,
 	}
+	for _, opt := range opts {
+		opt(ov)
+	}
+	return ov
+}
+
+// StoreData stores the key-value pair in the vault under the next free version.
+// Concurrent writers to the same key path each get a distinct version; a writer that
+// loses the race re-reads the latest version and tries again.
+func (ov *OwlVault) StoreData(ctx context.Context, keyPath string, data map[string]interface{}) (int, error) {
+	return ov.storeData(ctx, keyPath, data, nil)
 }
 
-// Store stores the key-value pair in the vault.
-func (ov *OwlVault) StoreData(keyPath string, data map[string]interface{}) (int, error) {
-	// Check if version exists
-	version, err := ov.storage.LatestVersion(keyPath)
+// StoreDataIfVersion stores the key-value pair only if the latest version of keyPath is still
+// expectedVersion, 0 meaning that the key path must not exist yet. Otherwise, including when
+// another writer gets in first, it stores nothing and returns ErrVersionMismatch.
+func (ov *OwlVault) StoreDataIfVersion(ctx context.Context, keyPath string, data map[string]interface{}, expectedVersion int) (int, error) {
+	return ov.storeData(ctx, keyPath, data, &expectedVersion)
+}
+
+func (ov *OwlVault) storeData(ctx context.Context, keyPath string, data map[string]interface{}, expectedVersion *int) (int, error) {
+	record, err := ov.seal(ctx, keyPath, data)
 	if err != nil {
 		return 0, err
 	}
 
-	version += 1
+	for attempt := 0; attempt < maxStoreAttempts; attempt++ {
+		// Check if version exists
+		version, err := ov.latestVersion(ctx, keyPath)
+		if err != nil {
+			return 0, err
+		}
+		if err := checkExpectedVersion(keyPath, version, expectedVersion); err != nil {
+			return 0, err
+		}
 
-	b, err := json.Marshal(&data)
+		record.Version = version + 1
+
+		err = ov.store(ctx, record)
+		if errors.Is(err, storage.ErrVersionConflict) {
+			// A check-and-set write notices the other writer on the next check
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to store key-value pair: %v", err)
+		}
+
+		// The write already succeeded, so a pruning failure is left to the background pruner
+		if _, err := ov.PruneKey(ctx, keyPath); err != nil {
+			log.Printf("failed to apply retention policy to %s: %v", keyPath, err)
+		}
+		return record.Version, nil
+	}
+
+	return 0, fmt.Errorf("failed to store key-value pair after %d attempts: %w", maxStoreAttempts, storage.ErrVersionConflict)
+}
+
+// RetrieveVersion retrieves the value for the specified key and version from the vault.
+func (ov *OwlVault) RetrieveVersion(ctx context.Context, keyPath string, version int) (map[string]interface{}, error) {
+	// Implement logic to retrieve value from the storage backend
+	base64Value, base64HMAC, base64KPID, err := ov.retrieve(ctx, keyPath, version)
 	if err != nil {
-		return 0, fmt.Errorf("error marshaling data: %w", err)
+		return nil, err
 	}
 
-	encKey, hashKey, kpBlob, err := ov.keyProvider.GenerateKey()
+	if base64Value == "" {
+		return nil, ErrKeyNotFound
+	}
+
+	return ov.open(ctx, keyPath, base64Value, base64HMAC, base64KPID)
+}
+
+// RetrieveLatestVersion retrieves the value for the specified key and latest version from the vault.
+func (ov *OwlVault) RetrieveLatestVersion(ctx context.Context, keyPath string) (map[string]interface{}, error) {
+	version, err := ov.latestVersion(ctx, keyPath)
 	if err != nil {
-		return 0, fmt.Errorf("error generating key: %w", err)
+		return nil, err
 	}
 
-	// Implement logic to store key-value pair in the storage backend
-	encryptedValue, err := ov.encryptor.Encrypt(encKey, b)
+	if version < 1 {
+		return nil, ErrKeyNotFound
+	}
+
+	return ov.RetrieveVersion(ctx, keyPath, version)
+}
+
+// ListKeys returns a page of key paths starting with prefix together with their latest versions,
+// and the token to pass back for the next page. An empty token means there are no more pages.
+func (ov *OwlVault) ListKeys(ctx context.Context, prefix string, limit int, token string) ([]storage.KeySummary, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	defer cancel()
+	return ov.storage.List(ctx, prefix, limit, token)
+}
+
+// DescribeKey returns the metadata and deletion state of every version of keyPath without decrypting anything.
+func (ov *OwlVault) DescribeKey(ctx context.Context, keyPath string) ([]storage.VersionInfo, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	defer cancel()
+
+	versions, err := ov.storage.Versions(ctx, keyPath)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrKeyNotFound
 	}
+	return versions, nil
+}
 
-	// Calculate HMAC of the value
-	hmacValue := ov.generateHMAC(hashKey, b)
+// DeleteVersions soft-deletes the given versions of keyPath, or its latest version when none are given.
+// It returns the versions that were deleted, which on error are those deleted before the failure.
+func (ov *OwlVault) DeleteVersions(ctx context.Context, keyPath string, versions []int) ([]int, error) {
+	return ov.applyToVersions(ctx, keyPath, versions, notDestroyed, ov.storage.Delete)
+}
 
-	// Convert encrypted value to base64 encoding
-	base64Value := base64.StdEncoding.EncodeToString(encryptedValue)
-	base64HMAC := base64.StdEncoding.EncodeToString(hmacValue)
-	base64KPId := base64.StdEncoding.EncodeToString(kpBlob)
+// UndeleteVersions recovers the given soft-deleted versions of keyPath, or its latest version when none are given.
+// It returns the versions that were recovered, which on error are those recovered before the failure.
+func (ov *OwlVault) UndeleteVersions(ctx context.Context, keyPath string, versions []int) ([]int, error) {
+	return ov.applyToVersions(ctx, keyPath, versions, notDestroyed, ov.storage.Undelete)
+}
 
-	// Implement logic to store key-value pair in the storage backend
-	if err := ov.storage.Store(keyPath, base64Value, base64HMAC, base64KPId, version); err != nil {
-		return 0, fmt.Errorf("failed to store key-value pair: %v", err)
+// DestroyVersions permanently erases the contents of the given versions of keyPath.
+// It returns the versions that were destroyed, which on error are those destroyed before the failure.
+func (ov *OwlVault) DestroyVersions(ctx context.Context, keyPath string, versions []int) ([]int, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no versions specified")
 	}
-	return version, nil
+	return ov.applyToVersions(ctx, keyPath, versions, nil, ov.storage.Destroy)
 }
 
-// RetrieveVersion retrieves the value for the specified key and version from the vault.
-func (ov *OwlVault) RetrieveVersion(keyPath string, version int) (map[string]interface{}, error) {
-	var data map[string]interface{}
+// notDestroyed rejects destroyed versions, whose deletion state can no longer change.
+func notDestroyed(info storage.VersionInfo) error {
+	if info.DestroyedAt != nil {
+		return storage.ErrVersionDestroyed
+	}
+	return nil
+}
 
-	// Implement logic to retrieve value from the storage backend
-	base64Value, base64HMAC, base64KPID, err := ov.storage.Retrieve(keyPath, version)
+// applyToVersions runs a deletion state change on each version, defaulting to the latest one.
+// Every version is checked to exist and pass check before any of them is changed, so that a
+// request naming a missing or destroyed version changes nothing. A version can still fail
+// afterwards, for instance if it is destroyed concurrently; the versions already changed are
+// then returned along with the error.
+func (ov *OwlVault) applyToVersions(ctx context.Context, keyPath string, versions []int, check func(storage.VersionInfo) error, fn func(context.Context, string, int) error) ([]int, error) {
+	readCtx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	infos, err := ov.storage.Versions(readCtx, keyPath)
+	cancel()
 	if err != nil {
 		return nil, err
 	}
+	if len(infos) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	if len(versions) == 0 {
+		versions = []int{infos[len(infos)-1].Version}
+	}
 
-	if base64Value == "" {
-		return nil, fmt.Errorf("NO_KEY_FOUND")
+	stored := make(map[int]storage.VersionInfo, len(infos))
+	for _, info := range infos {
+		stored[info.Version] = info
+	}
+	for _, version := range versions {
+		info, ok := stored[version]
+		if !ok {
+			return nil, fmt.Errorf("version %d: %w", version, storage.ErrVersionNotFound)
+		}
+		if check != nil {
+			if err := check(info); err != nil {
+				return nil, fmt.Errorf("version %d: %w", version, err)
+			}
+		}
+	}
+
+	applied := make([]int, 0, len(versions))
+	for _, version := range versions {
+		ctx, cancel := withTimeout(ctx, ov.timeouts.StorageWrite)
+		err := fn(ctx, keyPath, version)
+		cancel()
+		if err != nil {
+			return applied, fmt.Errorf("version %d: %w", version, err)
+		}
+		applied = append(applied, version)
+	}
+
+	return applied, nil
+}
+
+// checkExpectedVersion returns ErrVersionMismatch when an expected version is given and differs from latest.
+func checkExpectedVersion(keyPath string, latest int, expectedVersion *int) error {
+	if expectedVersion != nil && *expectedVersion != latest {
+		return fmt.Errorf("%w: %s is at version %d, expected %d", ErrVersionMismatch, keyPath, latest, *expectedVersion)
 	}
+	return nil
+}
+
+// seal encrypts data under a fresh data key and returns the record to store, without a version.
+func (ov *OwlVault) seal(ctx context.Context, keyPath string, data map[string]interface{}) (storage.Record, error) {
+	b, err := json.Marshal(&data)
+	if err != nil {
+		return storage.Record{}, fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	encKey, hashKey, kpBlob, err := ov.generateKey(ctx, keyPath)
+	if err != nil {
+		return storage.Record{}, fmt.Errorf("error generating key: %w", err)
+	}
+
+	// Implement logic to store key-value pair in the storage backend
+	encryptedValue, err := ov.encryptor.Encrypt(encKey, b)
+	if err != nil {
+		return storage.Record{}, err
+	}
+
+	// Calculate HMAC of the value
+	hmacValue := ov.generateHMAC(hashKey, b)
+
+	// Convert encrypted value to base64 encoding
+	return storage.Record{
+		KeyPath:  keyPath,
+		Contents: base64.StdEncoding.EncodeToString(encryptedValue),
+		HMAC:     base64.StdEncoding.EncodeToString(hmacValue),
+		KPId:     base64.StdEncoding.EncodeToString(kpBlob),
+		Metadata: storage.Metadata{
+			CreatedAt:   time.Now().UTC(),
+			Encryptor:   ov.encryptorType,
+			KeyProvider: ov.keyProviderType,
+			CreatedBy:   CallerFromContext(ctx),
+		},
+	}, nil
+}
+
+// open decrypts contents stored at keyPath and checks them against their HMAC.
+func (ov *OwlVault) open(ctx context.Context, keyPath, base64Value, base64HMAC, base64KPID string) (map[string]interface{}, error) {
+	var data map[string]interface{}
 
 	// Decode the base64-encoded value
 	encryptedValue, err := base64.StdEncoding.DecodeString(base64Value)
@@ -94,7 +360,7 @@ func (ov *OwlVault) RetrieveVersion(keyPath string, version int) (map[string]int
 		return nil, fmt.Errorf("failed to decode base64 key provider id: %v", err)
 	}
 
-	encKey, hashKey, err := ov.keyProvider.RetrieveKey(kpBlob)
+	encKey, hashKey, err := ov.retrieveKey(ctx, keyPath, kpBlob)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve key from key provider: %v", err)
 	}
@@ -124,20 +390,6 @@ func (ov *OwlVault) RetrieveVersion(keyPath string, version int) (map[string]int
 	return data, nil
 }
 
-// RetrieveLatestVersion retrieves the value for the specified key and latest version from the vault.
-func (ov *OwlVault) RetrieveLatestVersion(keyPath string) (map[string]interface{}, error) {
-	version, err := ov.storage.LatestVersion(keyPath)
-	if err != nil {
-		return nil, err
-	}
-
-	if version < 1 {
-		return nil, fmt.Errorf("NO_KEY_FOUND")
-	}
-
-	return ov.RetrieveVersion(keyPath, version)
-}
-
 // Additional methods for OwlVault can be added as needed.
 func (ov *OwlVault) generateHMAC(hashKey []byte, data []byte) []byte {
 	// Calculate HMAC of the decrypted value
@@ -145,3 +397,51 @@ func (ov *OwlVault) generateHMAC(hashKey []byte, data []byte) []byte {
 	h.Write(data)
 	return h.Sum(nil)
 }
+
+func (ov *OwlVault) generateKey(ctx context.Context, keyPath string) ([]byte, []byte, []byte, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.KeyProvider)
+	defer cancel()
+	return ov.keyProvider.GenerateKey(ctx, ov.encryptionContext(keyPath))
+}
+
+func (ov *OwlVault) retrieveKey(ctx context.Context, keyPath string, kpBlob []byte) ([]byte, []byte, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.KeyProvider)
+	defer cancel()
+	return ov.keyProvider.RetrieveKey(ctx, kpBlob, ov.encryptionContext(keyPath))
+}
+
+// encryptionContext is the context the data key of a version stored at keyPath is bound to,
+// so that its key provider blob cannot be used for another key path or tenant.
+func (ov *OwlVault) encryptionContext(keyPath string) map[string]string {
+	encryptionContext := map[string]string{EncryptionContextKeyPath: keyPath}
+	if ov.tenant != "" {
+		encryptionContext[EncryptionContextTenant] = ov.tenant
+	}
+	return encryptionContext
+}
+
+func (ov *OwlVault) store(ctx context.Context, record storage.Record) error {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageWrite)
+	defer cancel()
+	return ov.storage.Store(ctx, record)
+}
+
+func (ov *OwlVault) retrieve(ctx context.Context, keyPath string, version int) (string, string, string, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	defer cancel()
+	return ov.storage.Retrieve(ctx, keyPath, version)
+}
+
+func (ov *OwlVault) latestVersion(ctx context.Context, keyPath string) (int, error) {
+	ctx, cancel := withTimeout(ctx, ov.timeouts.StorageRead)
+	defer cancel()
+	return ov.storage.LatestVersion(ctx, keyPath)
+}
+
+// withTimeout derives a context bounded by timeout, or just cancellable when timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
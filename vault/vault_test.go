@@ -0,0 +1,377 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ngoyal16/owlvault/encrypt"
+	"github.com/ngoyal16/owlvault/keyprovider/localfile"
+	"github.com/ngoyal16/owlvault/storage"
+	"github.com/ngoyal16/owlvault/storage/memory"
+)
+
+// newTestVault returns an OwlVault on the given storage, or on a fresh memory storage if it is
+// nil, with a local file key provider in a temporary directory and the AES encryptor.
+func newTestVault(t *testing.T, s storage.Storage, opts ...Option) *OwlVault {
+	t.Helper()
+
+	if s == nil {
+		var err error
+		if s, err = memory.NewMemoryStorage(); err != nil {
+			t.Fatalf("NewMemoryStorage: %v", err)
+		}
+	}
+	keyProvider, err := localfile.NewLocalFileKeyProvider(filepath.Join(t.TempDir(), "master.key"))
+	if err != nil {
+		t.Fatalf("NewLocalFileKeyProvider: %v", err)
+	}
+	encryptor, err := encrypt.NewAESEncryptor()
+	if err != nil {
+		t.Fatalf("NewAESEncryptor: %v", err)
+	}
+	return NewOwlVault(s, keyProvider, encryptor, opts...)
+}
+
+// racingStorage lets another writer take the next version of a key path right after its
+// latest version is first read, so that the write based on that read loses the race.
+type racingStorage struct {
+	storage.Storage
+
+	mu    sync.Mutex
+	raced map[string]bool
+}
+
+func (s *racingStorage) LatestVersion(ctx context.Context, keyPath string) (int, error) {
+	version, err := s.Storage.LatestVersion(ctx, keyPath)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.raced[keyPath] {
+		s.raced[keyPath] = true
+		if err := s.Storage.Store(ctx, storage.Record{KeyPath: keyPath, Version: version + 1}); err != nil {
+			return 0, err
+		}
+	}
+	return version, nil
+}
+
+func TestStoreAndRetrieve(t *testing.T) {
+	ctx := context.Background()
+	ov := newTestVault(t, nil)
+
+	writes := []map[string]interface{}{
+		{"user": "admin", "password": "one"},
+		{"user": "admin", "password": "two"},
+		{"nested": map[string]interface{}{"list": []interface{}{"a", "b"}}},
+	}
+	for i, data := range writes {
+		version, err := ov.StoreData(ctx, "app/db", data)
+		if err != nil {
+			t.Fatalf("StoreData: %v", err)
+		}
+		if version != i+1 {
+			t.Fatalf("StoreData: got version %d, want %d", version, i+1)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		keyPath string
+		version int
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{name: "first version", keyPath: "app/db", version: 1, want: writes[0]},
+		{name: "second version", keyPath: "app/db", version: 2, want: writes[1]},
+		{name: "latest version", keyPath: "app/db", want: writes[2]},
+		{name: "missing version", keyPath: "app/db", version: 4, wantErr: ErrKeyNotFound},
+		{name: "missing key path", keyPath: "app/smtp", wantErr: ErrKeyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			var err error
+			if tt.version == 0 {
+				got, err = ov.RetrieveLatestVersion(ctx, tt.keyPath)
+			} else {
+				got, err = ov.RetrieveVersion(ctx, tt.keyPath, tt.version)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreDataRetriesLostRace(t *testing.T) {
+	tests := []struct {
+		name        string
+		race        bool
+		stored      int
+		wantVersion int
+	}{
+		{name: "new key", wantVersion: 1},
+		{name: "next version", stored: 1, wantVersion: 2},
+		{name: "lost race is retried", race: true, stored: 2, wantVersion: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := memory.NewMemoryStorage()
+			if err != nil {
+				t.Fatalf("NewMemoryStorage: %v", err)
+			}
+			ov := newTestVault(t, s)
+			for i := 0; i < tt.stored; i++ {
+				if _, err := ov.StoreData(ctx, "kv", map[string]interface{}{"i": i}); err != nil {
+					t.Fatalf("StoreData: %v", err)
+				}
+			}
+			if tt.race {
+				ov = newTestVault(t, &racingStorage{Storage: s, raced: map[string]bool{}})
+			}
+
+			version, err := ov.StoreData(ctx, "kv", map[string]interface{}{"key": "value"})
+			if err != nil {
+				t.Fatalf("StoreData: %v", err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("got version %d, want %d", version, tt.wantVersion)
+			}
+			if latest, err := s.LatestVersion(ctx, "kv"); err != nil || latest != tt.wantVersion {
+				t.Errorf("LatestVersion: got %d, %v, want %d", latest, err, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestStoreDataConcurrentWriters(t *testing.T) {
+	ctx := context.Background()
+	ov := newTestVault(t, nil)
+
+	const writers = 4
+	versions := make([]int, writers)
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			versions[i], errs[i] = ov.StoreData(ctx, "kv", map[string]interface{}{"writer": i})
+		}(i)
+	}
+	wg.Wait()
+
+	// Every writer that succeeded got a version of its own
+	seen := map[int]bool{}
+	for i, version := range versions {
+		if errs[i] != nil {
+			if !errors.Is(errs[i], storage.ErrVersionConflict) {
+				t.Errorf("writer %d: %v", i, errs[i])
+			}
+			continue
+		}
+		if seen[version] {
+			t.Errorf("writer %d: version %d was handed out twice", i, version)
+		}
+		seen[version] = true
+	}
+}
+
+func TestDeletionStateTransitions(t *testing.T) {
+	type step struct {
+		action      string
+		versions    []int
+		wantApplied []int
+		wantErr     error
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		// want is the error retrieving each of the three versions returns at the end.
+		want []error
+	}{
+		{
+			name:  "delete the latest version by default",
+			steps: []step{{action: "delete", wantApplied: []int{3}}},
+			want:  []error{nil, nil, storage.ErrVersionDeleted},
+		},
+		{
+			name: "delete and undelete",
+			steps: []step{
+				{action: "delete", versions: []int{1, 2}, wantApplied: []int{1, 2}},
+				{action: "undelete", versions: []int{1}, wantApplied: []int{1}},
+			},
+			want: []error{nil, storage.ErrVersionDeleted, nil},
+		},
+		{
+			name: "deleting twice is a no-op",
+			steps: []step{
+				{action: "delete", versions: []int{2}, wantApplied: []int{2}},
+				{action: "delete", versions: []int{2}, wantApplied: []int{2}},
+			},
+			want: []error{nil, storage.ErrVersionDeleted, nil},
+		},
+		{
+			name: "destroy a deleted version",
+			steps: []step{
+				{action: "delete", versions: []int{1}, wantApplied: []int{1}},
+				{action: "destroy", versions: []int{1}, wantApplied: []int{1}},
+			},
+			want: []error{storage.ErrVersionDestroyed, nil, nil},
+		},
+		{
+			name: "a destroyed version cannot be undeleted",
+			steps: []step{
+				{action: "destroy", versions: []int{2}, wantApplied: []int{2}},
+				{action: "undelete", versions: []int{2}, wantErr: storage.ErrVersionDestroyed},
+				{action: "delete", versions: []int{2}, wantErr: storage.ErrVersionDestroyed},
+			},
+			want: []error{nil, storage.ErrVersionDestroyed, nil},
+		},
+		{
+			name: "a missing version changes nothing",
+			steps: []step{
+				{action: "delete", versions: []int{1, 4}, wantErr: storage.ErrVersionNotFound},
+				{action: "destroy", versions: []int{2, 4}, wantErr: storage.ErrVersionNotFound},
+			},
+			want: []error{nil, nil, nil},
+		},
+		{
+			name: "a destroyed version among others changes nothing",
+			steps: []step{
+				{action: "destroy", versions: []int{3}, wantApplied: []int{3}},
+				{action: "delete", versions: []int{1, 3}, wantErr: storage.ErrVersionDestroyed},
+			},
+			want: []error{nil, nil, storage.ErrVersionDestroyed},
+		},
+		{
+			name:  "destroy needs versions",
+			steps: []step{{action: "destroy", wantErr: errAny}},
+			want:  []error{nil, nil, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := memory.NewMemoryStorage()
+			if err != nil {
+				t.Fatalf("NewMemoryStorage: %v", err)
+			}
+			ov := newTestVault(t, s)
+			for i := 1; i <= 3; i++ {
+				if _, err := ov.StoreData(ctx, "kv", map[string]interface{}{"version": i}); err != nil {
+					t.Fatalf("StoreData: %v", err)
+				}
+			}
+
+			for _, step := range tt.steps {
+				var applied []int
+				switch step.action {
+				case "delete":
+					applied, err = ov.DeleteVersions(ctx, "kv", step.versions)
+				case "undelete":
+					applied, err = ov.UndeleteVersions(ctx, "kv", step.versions)
+				case "destroy":
+					applied, err = ov.DestroyVersions(ctx, "kv", step.versions)
+				}
+
+				if step.wantErr == errAny {
+					if err == nil {
+						t.Fatalf("%s %v: succeeded, want an error", step.action, step.versions)
+					}
+				} else if !errors.Is(err, step.wantErr) {
+					t.Fatalf("%s %v: got error %v, want %v", step.action, step.versions, err, step.wantErr)
+				}
+				if len(applied) != 0 || len(step.wantApplied) != 0 {
+					if !reflect.DeepEqual(applied, step.wantApplied) {
+						t.Errorf("%s %v: applied %v, want %v", step.action, step.versions, applied, step.wantApplied)
+					}
+				}
+			}
+
+			for i, want := range tt.want {
+				_, _, _, err := s.Retrieve(ctx, "kv", i+1)
+				if !errors.Is(err, want) {
+					t.Errorf("version %d: got error %v, want %v", i+1, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeletionStateOfMissingKey(t *testing.T) {
+	ov := newTestVault(t, nil)
+
+	if _, err := ov.DeleteVersions(context.Background(), "missing", nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("DeleteVersions: got error %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, err := ov.DescribeKey(context.Background(), "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("DescribeKey: got error %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+// errAny stands for any error in test tables.
+var errAny = errors.New("any error")
+
+func TestStoreDataIfVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		race            bool
+		stored          int
+		expectedVersion int
+		wantVersion     int
+		wantErr         error
+		wantLatest      int
+	}{
+		{name: "expected version matches", stored: 2, expectedVersion: 2, wantVersion: 3, wantLatest: 3},
+		{name: "new key expected", expectedVersion: 0, wantVersion: 1, wantLatest: 1},
+		{name: "expected version is stale", stored: 2, expectedVersion: 1, wantErr: ErrVersionMismatch, wantLatest: 2},
+		{name: "key expected not to exist", stored: 1, expectedVersion: 0, wantErr: ErrVersionMismatch, wantLatest: 1},
+		{name: "lost race", race: true, stored: 2, expectedVersion: 2, wantErr: ErrVersionMismatch, wantLatest: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := memory.NewMemoryStorage()
+			if err != nil {
+				t.Fatalf("NewMemoryStorage: %v", err)
+			}
+			ov := newTestVault(t, s)
+			for i := 0; i < tt.stored; i++ {
+				if _, err := ov.StoreData(ctx, "kv", map[string]interface{}{"i": i}); err != nil {
+					t.Fatalf("StoreData: %v", err)
+				}
+			}
+			if tt.race {
+				ov = newTestVault(t, &racingStorage{Storage: s, raced: map[string]bool{}})
+			}
+
+			version, err := ov.StoreDataIfVersion(ctx, "kv", map[string]interface{}{"key": "value"}, tt.expectedVersion)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("got version %d, want %d", version, tt.wantVersion)
+			}
+			if latest, err := s.LatestVersion(ctx, "kv"); err != nil || latest != tt.wantLatest {
+				t.Errorf("LatestVersion: got %d, %v, want %d", latest, err, tt.wantLatest)
+			}
+		})
+	}
+}